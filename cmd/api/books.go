@@ -15,6 +15,10 @@ func (app *application) postBookHandler(w http.ResponseWriter, r *http.Request)
 		Author      string `json:"author"`
 		ImageURL    string `json:"image_url"`
 		Description string `json:"description"`
+		Authors     []struct {
+			AuthorID int64  `json:"author_id"`
+			Role     string `json:"role"`
+		} `json:"authors"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -30,21 +34,45 @@ func (app *application) postBookHandler(w http.ResponseWriter, r *http.Request)
 		Description: input.Description,
 	}
 
+	var credits []data.BookAuthor
+	for _, credit := range input.Authors {
+		if credit.Role == "" {
+			credit.Role = "author"
+		}
+		credits = append(credits, data.BookAuthor{AuthorID: credit.AuthorID, Role: credit.Role})
+	}
+
 	v := validator.New()
 
-	if data.ValidateBook(v, book); !v.Valid() {
+	data.ValidateBook(v, book)
+
+	if input.Authors != nil {
+		data.ValidateBookAuthors(v, credits)
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Books.Insert(book)
+	err = app.models.Books.Insert(book, credits)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("authors", "must only reference existing authors")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("authors", "must not credit the same author twice in one role")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/book/%d", book.ID))
+	headers.Set("ETag", etag(book.Version))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
 	if err != nil {
@@ -70,7 +98,36 @@ func (app *application) getBookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	book.Authors, err = app.models.Authors.GetForBook(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	book.Genres, err = app.models.Genres.GetForBook(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	book.Availability, err = app.models.Copies.AvailabilityForBook(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", availabilityETag(book.Version, book.Availability.Version))
+
+	if app.notModified(r, headers.Get("ETag")) {
+		err = app.writeJSON(w, http.StatusNotModified, nil, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -88,19 +145,14 @@ func (app *application) putBookHandler(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(book.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-			return
-		}
+	if !app.checkIfMatch(w, r, etag(book.Version)) {
+		return
 	}
 
 	var input struct {
@@ -130,10 +182,101 @@ func (app *application) putBookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = app.models.Books.Update(book)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(book.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) patchBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, etag(book.Version)) {
 		return
 	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Author      *string `json:"author"`
+		ImageURL    *string `json:"image_url"`
+		Description *string `json:"description"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		book.Title = *input.Title
+	}
+
+	if input.Author != nil {
+		book.Author = *input.Author
+	}
+
+	if input.ImageURL != nil {
+		book.ImageURL = *input.ImageURL
+	}
+
+	if input.Description != nil {
+		book.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	if data.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Books.Update(book)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(book.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -143,7 +286,7 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Books.Delete(id)
+	book, err := app.models.Books.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -154,6 +297,25 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.checkIfMatch(w, r, etag(book.Version)) {
+		return
+	}
+
+	err = app.models.Books.Delete(book.ID, book.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrHasLoanHistory):
+			app.conflictResponse(w, r, "this book has copies with loan history and cannot be deleted, withdraw its copies instead")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -164,31 +326,60 @@ func (app *application) getAllBooksHandler(w http.ResponseWriter, r *http.Reques
 	var input struct {
 		Title  string
 		Author string
+		Genre  int
 		data.Filter
 	}
 
 	params := r.URL.Query()
 
+	var err error
 	v := validator.New()
 	input.Title = app.readString(params, "title", "")
 	input.Author = app.readString(params, "author", "")
+	input.Genre = app.readInt(params, "genre", 0, v)
 	input.Filter.Page = app.readInt(params, "page", 1, v)
 	input.Filter.PageSize = app.readInt(params, "page_size", 20, v)
 	input.Filter.Sort = app.readString(params, "sort", "id")
 	input.Filter.SortSafeList = []string{"id", "title", "author", "created_at", "-id", "-title", "-author", "-created_at"}
+	input.Filter.Fingerprint = data.Fingerprint(input.Title, input.Author, strconv.Itoa(input.Genre))
+
+	if cursor := app.readString(params, "cursor", ""); cursor != "" {
+		input.Filter.Cursor, err = data.DecodeCursor(cursor, []byte(app.config.cursorSecret))
+		if err != nil {
+			v.AddError("cursor", "is invalid or has been tampered with")
+		}
+		v.Check(!params.Has("page"), "page", "must not be supplied together with a cursor")
+	}
+
+	v.Check(input.Genre >= 0, "genre", "must be a valid genre id")
 
 	if data.ValidateFilters(v, input.Filter); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	books, err := app.models.Books.GetAll(input.Title, input.Author, input.Filter)
+	books, metadata, err := app.models.Books.GetAll(input.Title, input.Author, int64(input.Genre), input.Filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books}, nil)
+	genres, err := app.models.Genres.CountsForBooks(books)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if next := data.NextBookCursor(input.Filter, books); next != nil {
+		metadata.NextCursor = next.Encode([]byte(app.config.cursorSecret))
+	}
+
+	headers := make(http.Header)
+	if link := app.paginationLinks(r, metadata); link != "" {
+		headers.Set("Link", link)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "genres": genres, "metadata": metadata}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/jsonlog"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	userContextKey           = contextKey("user")
+	apiKeyContextKey         = contextKey("api_key")
+	jwtPermissionsContextKey = contextKey("jwt_permissions")
+	requestIDContextKey      = contextKey("request_id")
+	accessLogContextKey      = contextKey("access_log")
+)
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// The access log sits outside authenticate, so it can't see this request
+	// value; hand it the user through the entry it placed in the context.
+	ctx := r.Context()
+
+	if !user.IsAnonymous() {
+		if entry, ok := ctx.Value(accessLogContextKey).(*accessLogEntry); ok {
+			entry.userID = user.ID
+		}
+
+		ctx = jsonlog.NewContext(ctx, jsonlog.FromContext(ctx).With(jsonlog.Int64("user_id", user.ID)))
+	}
+
+	ctx = context.WithValue(ctx, userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetJWTPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), jwtPermissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetJWTPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(jwtPermissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
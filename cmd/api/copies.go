@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/validator"
+	"net/http"
+)
+
+func (app *application) readCopyFromParams(w http.ResponseWriter, r *http.Request) (*data.Copy, bool) {
+	bookID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	copyID, err := app.readNamedIDFromParam(r, "copy_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	bookCopy, err := app.models.Copies.Get(bookID, copyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return bookCopy, true
+}
+
+func (app *application) postCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Barcode       string `json:"barcode"`
+		Branch        string `json:"branch"`
+		ShelfLocation string `json:"shelf_location"`
+		Condition     string `json:"condition"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bookCopy := &data.Copy{
+		BookID:        bookID,
+		Barcode:       input.Barcode,
+		Branch:        input.Branch,
+		ShelfLocation: input.ShelfLocation,
+		Condition:     input.Condition,
+		Status:        data.CopyStatusAvailable,
+	}
+
+	if bookCopy.Condition == "" {
+		bookCopy.Condition = "good"
+	}
+
+	v := validator.New()
+
+	if data.ValidateCopy(v, bookCopy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Copies.Insert(bookCopy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/books/%d/copies/%d", bookCopy.BookID, bookCopy.ID))
+	headers.Set("ETag", etag(bookCopy.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"copy": bookCopy}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getAllCopiesHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Books.Get(bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	copies, err := app.models.Copies.GetAllForBook(bookID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copies": copies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := app.readCopyFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(bookCopy.Version))
+
+	if app.notModified(r, headers.Get("ETag")) {
+		err := app.writeJSON(w, http.StatusNotModified, nil, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"copy": bookCopy}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) patchCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := app.readCopyFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, etag(bookCopy.Version)) {
+		return
+	}
+
+	var input struct {
+		Barcode       *string `json:"barcode"`
+		Branch        *string `json:"branch"`
+		ShelfLocation *string `json:"shelf_location"`
+		Condition     *string `json:"condition"`
+		Status        *string `json:"status"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Barcode != nil {
+		bookCopy.Barcode = *input.Barcode
+	}
+
+	if input.Branch != nil {
+		bookCopy.Branch = *input.Branch
+	}
+
+	if input.ShelfLocation != nil {
+		bookCopy.ShelfLocation = *input.ShelfLocation
+	}
+
+	if input.Condition != nil {
+		bookCopy.Condition = *input.Condition
+	}
+
+	v := validator.New()
+
+	if input.Status != nil && *input.Status != bookCopy.Status {
+		v.Check(*input.Status != data.CopyStatusOnLoan, "status", "copies can only be put on loan by borrowing them")
+		v.Check(bookCopy.Status != data.CopyStatusOnLoan, "status", "copies on loan can only be made available by returning them")
+		bookCopy.Status = *input.Status
+	}
+
+	if data.ValidateCopy(v, bookCopy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Copies.Update(bookCopy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(bookCopy.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": bookCopy}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := app.readCopyFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, etag(bookCopy.Version)) {
+		return
+	}
+
+	err := app.models.Copies.Delete(bookCopy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrHasLoanHistory):
+			app.conflictResponse(w, r, "this copy has loan history and cannot be deleted, set its status to withdrawn instead")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "copy successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) finePolicy() data.FinePolicy {
+	return data.FinePolicy{
+		DailyCents:      app.config.fines.dailyCents,
+		CapCents:        app.config.fines.capCents,
+		GraceDays:       app.config.fines.graceDays,
+		MaxBalanceCents: app.config.fines.maxBalanceCents,
+	}
+}
+
+func (app *application) getUserBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	balance, err := app.models.Fines.GetBalance(app.finePolicy(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"balance": balance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) postUserPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	app.creditUser(w, r, data.LedgerKindPayment)
+}
+
+func (app *application) postUserWaiverHandler(w http.ResponseWriter, r *http.Request) {
+	app.creditUser(w, r, data.LedgerKindWaiver)
+}
+
+func (app *application) creditUser(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AmountCents int64  `json:"amount_cents"`
+		LoanID      *int64 `json:"loan_id"`
+		Description string `json:"description"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &data.LedgerEntry{
+		UserID:      userID,
+		LoanID:      input.LoanID,
+		Kind:        kind,
+		AmountCents: input.AmountCents,
+		Description: input.Description,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Fines.Credit(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownUser):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("loan_id", "must reference one of this user's loans")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCreditExceedsOwed):
+			v.AddError("amount_cents", "must not be more than the outstanding balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) accrueFines() {
+	charged, err := app.models.Fines.AccrueAll(app.finePolicy())
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if charged > 0 {
+		app.logger.PrintInfo("accrued overdue fines", map[string]string{
+			"charges": strconv.FormatInt(charged, 10),
+		})
+	}
+}
@@ -1,21 +1,187 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/jsonlog"
 	"net/http"
+	"sync"
+	"time"
 )
 
+const (
+	healthcheckTimeout = 2 * time.Second
+
+	// readinessCacheTTL bounds how often probes reach the database, however
+	// many callers poll the readiness endpoint.
+	readinessCacheTTL = 5 * time.Second
+)
+
+// healthCheck carries the outcome of one readiness check. Only the status is
+// sent to clients; the error and details can name hosts, users and schema
+// state, so they go to the log instead.
+type healthCheck struct {
+	status  string
+	err     error
+	details map[string]any
+}
+
+type readinessCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	checks    map[string]healthCheck
+}
+
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+	}
+}
+
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
-		},
+		"status":      "available",
+		"system_info": app.systemInfo(),
 	}
 
 	err := app.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		http.Error(w, "The server encountered a problem and could not process your request", http.StatusInternalServerError)
 	}
 }
+
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := app.readinessChecks(r)
+
+	status := "available"
+	code := http.StatusOK
+	statuses := make(map[string]string, len(checks))
+
+	for name, check := range checks {
+		statuses[name] = check.status
+
+		if check.status == "fail" {
+			status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "checks": statuses, "system_info": app.systemInfo()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessChecks runs the checks at most once per readinessCacheTTL and logs
+// any that didn't pass through the request's logger.
+func (app *application) readinessChecks(r *http.Request) map[string]healthCheck {
+	app.readiness.mu.Lock()
+	defer app.readiness.mu.Unlock()
+
+	if app.readiness.checks != nil && time.Since(app.readiness.checkedAt) < readinessCacheTTL {
+		return app.readiness.checks
+	}
+
+	checks := map[string]healthCheck{
+		"database":   app.checkDatabase(),
+		"pool":       app.checkPool(),
+		"migrations": app.checkMigrations(),
+	}
+
+	logger := jsonlog.FromContext(r.Context())
+
+	for name, check := range checks {
+		fields := []jsonlog.Field{jsonlog.String("check", name), jsonlog.Any("details", check.details)}
+
+		switch check.status {
+		case "fail":
+			logger.Error(check.err, fields...)
+		case "warn":
+			logger.Warn(check.err.Error(), fields...)
+		}
+	}
+
+	app.readiness.checks = checks
+	app.readiness.checkedAt = time.Now()
+
+	return checks
+}
+
+func (app *application) checkDatabase() healthCheck {
+	start := time.Now()
+
+	err := app.models.Health.Ping(healthcheckTimeout)
+
+	check := healthCheck{
+		status:  "pass",
+		details: map[string]any{"latency_ms": time.Since(start).Milliseconds()},
+	}
+
+	if err != nil {
+		check.status = "fail"
+		check.err = err
+	}
+
+	return check
+}
+
+// checkPool reports how saturated the connection pool is but never fails:
+// a fully used pool is normal at peak load, and pulling every instance out
+// of rotation then would turn a busy period into an outage.
+func (app *application) checkPool() healthCheck {
+	stats := app.models.Health.Stats()
+
+	check := healthCheck{
+		status: "pass",
+		details: map[string]any{
+			"open":             stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+			"max_open":         stats.MaxOpenConnections,
+			"wait_count":       stats.WaitCount,
+			"wait_duration_ms": stats.WaitDuration.Milliseconds(),
+		},
+	}
+
+	if stats.MaxOpenConnections > 0 {
+		check.details["saturation"] = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+
+		if stats.InUse >= stats.MaxOpenConnections {
+			check.status = "warn"
+			check.err = errors.New("connection pool is saturated")
+		}
+	}
+
+	return check
+}
+
+func (app *application) checkMigrations() healthCheck {
+	current, dirty, err := app.models.Health.MigrationVersion(healthcheckTimeout)
+	if err != nil {
+		return healthCheck{status: "fail", err: err}
+	}
+
+	check := healthCheck{
+		status: "pass",
+		details: map[string]any{
+			"current":  current,
+			"expected": data.SchemaVersion,
+			"dirty":    dirty,
+		},
+	}
+
+	// A newer schema is expected during rolling deploys, since migrations run
+	// before the new binary ships; only an older or half-applied one fails.
+	switch {
+	case dirty:
+		check.status = "fail"
+		check.err = fmt.Errorf("migration %d did not complete", current)
+	case current < data.SchemaVersion:
+		check.status = "fail"
+		check.err = fmt.Errorf("schema is at version %d, expected at least %d", current, data.SchemaVersion)
+	}
+
+	return check
+}
@@ -5,26 +5,43 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/jsonlog"
 	"github.com/xuche123/bookwise/internal/validator"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]any
 
 func (app *application) readIDFromParam(r *http.Request) (int64, error) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return app.readNamedIDFromParam(r, "id")
+}
+
+func (app *application) readNamedIDFromParam(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	if status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return nil
+	}
+
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
 		return err
@@ -32,10 +49,6 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 
 	js = append(js, '\n')
 
-	for key, value := range headers {
-		w.Header()[key] = value
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(js)
@@ -122,3 +135,200 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return i
 }
+
+func etag(version int32) string {
+	return strconv.Quote(strconv.FormatInt(int64(version), 10))
+}
+
+// availabilityETag validates a book together with its live availability, so
+// conditional GETs notice loans and returns. The part before the dot is the
+// book's own version, which is all If-Match compares.
+func availabilityETag(version, circulationVersion int32) string {
+	return strconv.Quote(fmt.Sprintf("%d.%d", version, circulationVersion))
+}
+
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if dot := strings.IndexByte(candidate, '.'); dot > 0 && strings.HasPrefix(candidate, `"`) {
+			candidate = candidate[:dot] + `"`
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *application) notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	return etagMatches(header, etag, true)
+}
+
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if app.config.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	if !etagMatches(header, etag, false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+func (app *application) paginationLinks(r *http.Request, metadata data.Metadata) string {
+	link := func(rel string, set map[string]string) string {
+		u := *r.URL
+		qs := u.Query()
+		qs.Del("page")
+		qs.Del("cursor")
+		qs.Set("page_size", strconv.Itoa(metadata.PageSize))
+		for key, value := range set {
+			qs.Set(key, value)
+		}
+		u.RawQuery = qs.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	var links []string
+
+	if metadata.TotalRecords > 0 {
+		links = append(links, link("first", map[string]string{"page": strconv.Itoa(metadata.FirstPage)}))
+
+		if metadata.CurrentPage > metadata.FirstPage {
+			links = append(links, link("prev", map[string]string{"page": strconv.Itoa(min(metadata.CurrentPage-1, metadata.LastPage))}))
+		}
+
+		if metadata.CurrentPage < metadata.LastPage {
+			links = append(links, link("next", map[string]string{"page": strconv.Itoa(metadata.CurrentPage + 1)}))
+		}
+
+		links = append(links, link("last", map[string]string{"page": strconv.Itoa(metadata.LastPage)}))
+	} else if metadata.NextCursor != "" {
+		links = append(links, link("next", map[string]string{"cursor": metadata.NextCursor}))
+	}
+
+	return strings.Join(links, ", ")
+}
+
+// background runs fn in a goroutine that shutdown waits for. Panics are
+// recovered and logged through logger, so tasks spawned by a request should
+// pass the request's logger to keep its request_id.
+func (app *application) background(logger *jsonlog.Logger, fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error(fmt.Errorf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+func (app *application) userHasPermission(r *http.Request, code string) (bool, error) {
+	if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+
+	if permissions, ok := app.contextGetJWTPermissions(r); ok {
+		return permissions.Include(code), nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// userMayActFor reports whether the request may act on ownerID's records:
+// its own with selfCode, anyone's with code. Both go through
+// userHasPermission, so API key scopes and JWT claims bind the owner too.
+func (app *application) userMayActFor(r *http.Request, ownerID int64, selfCode, code string) (bool, error) {
+	if ownerID == app.contextGetUser(r).ID {
+		permitted, err := app.userHasPermission(r, selfCode)
+		if err != nil || permitted {
+			return permitted, err
+		}
+	}
+
+	return app.userHasPermission(r, code)
+}
+
+func (app *application) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if !app.trustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !app.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (app *application) trustedProxy(ip net.IP) bool {
+	for _, network := range app.config.limiter.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *application) periodically(interval time.Duration, fn func()) {
+	app.background(app.logger, func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestETag(t *testing.T) {
+	if got, want := etag(3), `"3"`; got != want {
+		t.Errorf("etag(3) = %s, want %s", got, want)
+	}
+
+	if got, want := availabilityETag(3, 7), `"3.7"`; got != want {
+		t.Errorf("availabilityETag(3, 7) = %s, want %s", got, want)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{"weak exact", `"3"`, `"3"`, true, true},
+		{"weak prefix ignored", `W/"3"`, `"3"`, true, true},
+		{"weak different version", `"4"`, `"3"`, true, false},
+		{"weak list", `"1", W/"2" ,"3"`, `"3"`, true, true},
+		{"weak wildcard", `*`, `"3"`, true, true},
+		{"weak availability exact", `"3.7"`, `"3.7"`, true, true},
+		{"weak availability stale circulation", `"3.6"`, `"3.7"`, true, false},
+		{"weak plain version against availability", `"3"`, `"3.7"`, true, false},
+
+		{"strong exact", `"3"`, `"3"`, false, true},
+		{"strong rejects weak", `W/"3"`, `"3"`, false, false},
+		{"strong different version", `"4"`, `"3"`, false, false},
+		{"strong list", `"1", "3"`, `"3"`, false, true},
+		{"strong wildcard", `*`, `"3"`, false, true},
+		{"strong ignores circulation suffix", `"3.7"`, `"3"`, false, true},
+		{"strong suffix with stale version", `"2.7"`, `"3"`, false, false},
+		{"strong unquoted", `3`, `"3"`, false, false},
+		{"empty header", ``, `"3"`, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t, want %t", tt.header, tt.etag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
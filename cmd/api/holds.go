@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) postHoldHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		UserID *int64 `json:"user_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if input.UserID == nil {
+		input.UserID = &user.ID
+	}
+
+	v := validator.New()
+
+	if v.Check(*input.UserID > 0, "user_id", "must be a valid user id"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permitted, err := app.userMayActFor(r, *input.UserID, "loans:self", "loans:manage")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	hold, err := app.models.Holds.Place(*input.UserID, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownUser):
+			v.AddError("user_id", "must reference an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyAvailable):
+			app.conflictResponse(w, r, "a copy of this book is available, please borrow it instead")
+		case errors.Is(err, data.ErrDuplicateHold):
+			app.conflictResponse(w, r, "this user already has a hold on this book")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/holds/%d", hold.ID))
+	headers.Set("ETag", etag(hold.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getBookHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Books.Get(bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	holds, err := app.models.Holds.GetQueueForBook(bookID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hold, err := app.models.Holds.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.holdAccessible(w, r, hold) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(hold.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hold, err := app.models.Holds.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.holdAccessible(w, r, hold) {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, etag(hold.Version)) {
+		return
+	}
+
+	err = app.models.Holds.Cancel(hold, app.config.holds.pickupWindow)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "hold successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) holdAccessible(w http.ResponseWriter, r *http.Request, hold *data.Hold) bool {
+	permitted, err := app.userMayActFor(r, hold.UserID, "loans:self", "loans:manage")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !permitted {
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+func (app *application) expireHolds() {
+	expired, err := app.models.Holds.ExpireLapsed(app.config.holds.pickupWindow)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if expired > 0 {
+		app.logger.PrintInfo("expired lapsed holds", map[string]string{
+			"count": strconv.Itoa(expired),
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/jsonlog"
+	"github.com/xuche123/bookwise/internal/validator"
+	"net/http"
+)
+
+func (app *application) borrowHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64 `json:"user_id"`
+		CopyID int64 `json:"copy_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.CopyID > 0, "copy_id", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loan, err := app.models.Loans.Borrow(input.UserID, input.CopyID, app.config.loans.period, app.config.holds.pickupWindow, app.finePolicy())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("copy_id", "must reference an existing copy")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownUser):
+			v.AddError("user_id", "must reference an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyUnavailable):
+			app.conflictResponse(w, r, "this copy is not available for borrowing")
+		case errors.Is(err, data.ErrFinesOutstanding):
+			app.conflictResponse(w, r, fmt.Sprintf("outstanding fines above %d cents must be paid before borrowing", app.config.fines.maxBalanceCents))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d/transactions", loan.UserID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"loan": loan}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) returnHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDFromParam(r, "transaction_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err := app.models.Loans.Return(id, app.config.holds.pickupWindow)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLoanClosed):
+			app.conflictResponse(w, r, "this loan has already been returned")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The return has already committed, so a failed accrual is left for the
+	// background job to pick up rather than reported to the client.
+	_, err = app.models.Fines.Accrue(app.finePolicy(), loan.UserID)
+	if err != nil {
+		jsonlog.FromContext(r.Context()).Error(err, jsonlog.Int64("loan_id", loan.ID))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) renewLoanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err := app.models.Loans.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.userMayActFor(r, loan.UserID, "loans:self", "loans:manage")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permitted {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err = app.models.Loans.Renew(id, app.config.loans.period, app.config.loans.maxRenewals)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLoanClosed):
+			app.conflictResponse(w, r, "this loan has already been returned")
+		case errors.Is(err, data.ErrHoldPending):
+			app.conflictResponse(w, r, "this loan cannot be renewed because another patron has placed a hold on the book")
+		case errors.Is(err, data.ErrRenewalLimitReached):
+			app.conflictResponse(w, r, fmt.Sprintf("this loan has already been renewed the maximum of %d times", app.config.loans.maxRenewals))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDFromParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filter
+	}
+
+	params := r.URL.Query()
+
+	v := validator.New()
+	input.Filter.Page = app.readInt(params, "page", 1, v)
+	input.Filter.PageSize = app.readInt(params, "page_size", 20, v)
+	input.Filter.Sort = app.readString(params, "sort", "-borrowed_at")
+	input.Filter.SortSafeList = []string{"id", "borrowed_at", "due_at", "-id", "-borrowed_at", "-due_at"}
+
+	if data.ValidateFilters(v, input.Filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loans, metadata, err := app.models.Loans.GetAllForUser(userID, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	if link := app.paginationLinks(r, metadata); link != "" {
+		headers.Set("Link", link)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loans": loans, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,34 +2,85 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"expvar"
 	"flag"
-	"fmt"
 	_ "github.com/lib/pq"
 	"github.com/xuche123/bookwise/internal/data"
 	"github.com/xuche123/bookwise/internal/jsonlog"
-	"net/http"
+	"github.com/xuche123/bookwise/internal/jwt"
+	"github.com/xuche123/bookwise/internal/mailer"
+	"github.com/xuche123/bookwise/internal/metrics"
+	"net"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	env             string
+	logLevel        string
+	shutdownTimeout time.Duration
+	requireIfMatch  bool
+	cursorSecret    string
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	loans struct {
+		period      time.Duration
+		maxRenewals int
+	}
+	holds struct {
+		pickupWindow   time.Duration
+		expiryInterval time.Duration
+	}
+	fines struct {
+		dailyCents      int64
+		capCents        int64
+		graceDays       int
+		maxBalanceCents int64
+		accrualInterval time.Duration
+	}
+	jwt struct {
+		keys   string
+		issuer string
+		ttl    time.Duration
+	}
+	limiter struct {
+		enabled        bool
+		rps            float64
+		burst          int
+		trustedProxies []*net.IPNet
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
+	config    config
+	logger    *jsonlog.Logger
+	models    data.Models
+	mailer    mailer.Mailer
+	jwtKeys   *jwt.KeySet
+	limiter   rateLimiter
+	registry  *metrics.Registry
+	readiness readinessCache
+	wg        sync.WaitGroup
+	done      chan struct{}
 }
 
 func main() {
@@ -37,15 +88,89 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "Minimum log level (debug|info|warn|error|fatal|off)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests and background tasks to finish on shutdown")
+	flag.StringVar(&cfg.cursorSecret, "cursor-secret", os.Getenv("BOOKWISE_CURSOR_SECRET"), "Secret used to sign pagination cursors")
+	flag.BoolVar(&cfg.requireIfMatch, "require-if-match", false, "Reject updates and deletes without an If-Match header")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("BOOKWISE_DB_DSN"), "Postgres DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	flag.DurationVar(&cfg.loans.period, "loan-period", 21*24*time.Hour, "Length of a loan")
+	flag.IntVar(&cfg.loans.maxRenewals, "loan-max-renewals", 2, "Maximum number of times a loan can be renewed")
+
+	flag.DurationVar(&cfg.holds.pickupWindow, "hold-pickup-window", 72*time.Hour, "How long a ready hold waits for pickup")
+	flag.DurationVar(&cfg.holds.expiryInterval, "hold-expiry-interval", time.Minute, "How often lapsed holds are expired")
+
+	flag.Int64Var(&cfg.fines.dailyCents, "fine-daily-cents", 25, "Overdue fine charged per day, in cents")
+	flag.Int64Var(&cfg.fines.capCents, "fine-cap-cents", 1000, "Maximum overdue fine per loan, in cents")
+	flag.IntVar(&cfg.fines.graceDays, "fine-grace-days", 1, "Days a loan can be overdue before fines accrue")
+	flag.Int64Var(&cfg.fines.maxBalanceCents, "fine-max-balance-cents", 500, "Outstanding balance above which borrowing is blocked, in cents")
+	flag.DurationVar(&cfg.fines.accrualInterval, "fine-accrual-interval", time.Hour, "How often overdue fines are accrued")
+
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", os.Getenv("BOOKWISE_JWT_KEYS"), "JWT keys as comma-separated kid:alg:base64-key entries, the first one signs")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "bookwise", "JWT issuer")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", time.Hour, "Lifetime of issued JWTs")
+
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Func("limiter-trusted-proxies", "Trusted proxy IPs or CIDR ranges whose X-Forwarded-For is honoured (space separated)", func(val string) error {
+		for _, entry := range strings.Fields(val) {
+			if !strings.Contains(entry, "/") {
+				if strings.Contains(entry, ":") {
+					entry += "/128"
+				} else {
+					entry += "/32"
+				}
+			}
+
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return err
+			}
+
+			cfg.limiter.trustedProxies = append(cfg.limiter.trustedProxies, network)
+		}
+		return nil
+	})
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("BOOKWISE_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("BOOKWISE_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "BookWise <no-reply@bookwise.local>", "SMTP sender")
+
 	flag.Parse()
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logLevel, err := jsonlog.ParseLevel(cfg.logLevel)
+	if err != nil {
+		jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(err, nil)
+	}
+
+	logger := jsonlog.New(os.Stdout, logLevel)
+	jsonlog.SetDefault(logger)
+
+	if cfg.cursorSecret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.cursorSecret = string(secret)
+		logger.PrintInfo("no cursor secret configured, pagination cursors will not survive a restart", nil)
+	}
+
+	jwtKeys, err := openKeySet(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.jwt.keys == "" {
+		logger.PrintInfo("no JWT keys configured, issued JWTs will not survive a restart", nil)
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -61,27 +186,84 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	started := time.Now()
+
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	expvar.Publish("uptime_seconds", expvar.Func(func() any {
+		return int64(time.Since(started).Seconds())
+	}))
+
+	registry := newRegistry(db)
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		jwtKeys:  jwtKeys,
+		registry: registry,
+		done:     make(chan struct{}),
 	}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	limiter := newMemoryRateLimiter(cfg.limiter.rps, cfg.limiter.burst)
+	app.limiter = limiter
+
+	app.periodically(time.Minute, func() { limiter.evictIdle(3 * time.Minute) })
+	app.periodically(cfg.holds.expiryInterval, app.expireHolds)
+	app.periodically(cfg.fines.accrualInterval, app.accrueFines)
+
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
+}
+
+func newRegistry(db *sql.DB) *metrics.Registry {
+	registry := metrics.NewRegistry()
+
+	registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	registry.NewGaugeFunc("db_open_connections", "Number of established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	registry.NewCounterFunc("db_wait_count_total", "Total number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	registry.NewCounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	registry.NewCounterFunc("db_max_idle_time_closed_total", "Total number of connections closed due to the idle time limit.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
 
-	logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  cfg.env,
+	registry.NewCounterFunc("data_record_not_found_total", "Total number of lookups that found no matching record.", func() float64 {
+		return float64(data.RecordNotFoundCount())
+	})
+	registry.NewCounterFunc("data_edit_conflicts_total", "Total number of updates rejected by optimistic locking.", func() float64 {
+		return float64(data.EditConflictCount())
+	})
+
+	registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
 	})
 
-	err = srv.ListenAndServe()
-	logger.PrintFatal(err, nil)
+	return registry
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -111,3 +293,11 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func openKeySet(cfg config) (*jwt.KeySet, error) {
+	if cfg.jwt.keys == "" {
+		return jwt.GenerateKeySet(cfg.jwt.issuer)
+	}
+
+	return jwt.ParseKeySet(cfg.jwt.issuer, cfg.jwt.keys)
+}
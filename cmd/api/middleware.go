@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/xuche123/bookwise/internal/data"
+	"github.com/xuche123/bookwise/internal/jsonlog"
+	"github.com/xuche123/bookwise/internal/metrics"
+	"github.com/xuche123/bookwise/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				w.Header().Set("Connection", "close")
+				app.serverErrorResponse(w, r, fmt.Errorf("%v", err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		if r.Header.Get("X-API-Key") != "" || r.Header.Get("Authorization") != "" {
+			if app.authFailuresExceeded(w, r) {
+				return
+			}
+		}
+
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(next, w, r, apiKey)
+			return
+		}
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		if strings.Count(token, ".") == 2 {
+			app.authenticateJWT(next, w, r, token)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) authFailureKey(r *http.Request) string {
+	return "auth-failure:ip:" + app.clientIP(r).String()
+}
+
+// authFailuresExceeded sends a 429 and reports true when the client has used
+// up its failed authentication bucket. Credential checks hit the database,
+// so clients that keep presenting bad credentials are throttled by IP before
+// the lookup happens.
+func (app *application) authFailuresExceeded(w http.ResponseWriter, r *http.Request) bool {
+	if !app.config.limiter.enabled {
+		return false
+	}
+
+	decision := app.limiter.Peek(app.authFailureKey(r))
+	if decision.allowed {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(decision.retryAfter.Seconds())))
+	app.rateLimitExceededResponse(w, r)
+
+	return true
+}
+
+// throttleAuthFailures applies the failed authentication gate to routes that
+// check credentials themselves, such as the login endpoint.
+func (app *application) throttleAuthFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.authFailuresExceeded(w, r) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordAuthenticationFailure spends a token from the client's failed
+// authentication bucket, which authFailuresExceeded checks before every
+// credential lookup.
+func (app *application) recordAuthenticationFailure(r *http.Request) {
+	if app.config.limiter.enabled {
+		app.limiter.Allow(app.authFailureKey(r))
+	}
+}
+
+func (app *application) authenticateAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if key.Expired() || !key.AllowsIP(app.clientIP(r)) {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > time.Minute {
+		logger := jsonlog.FromContext(r.Context())
+
+		app.background(logger, func() {
+			err := app.models.APIKeys.Touch(key.ID)
+			if err != nil {
+				logger.Error(err)
+			}
+		})
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+func (app *application) authenticateJWT(next http.Handler, w http.ResponseWriter, r *http.Request, token string) {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user := &data.User{ID: userID, Activated: claims.Activated}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetJWTPermissions(r, claims.Permissions)
+
+	next.ServeHTTP(w, r)
+}
+
+func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// rejectAPIKeys keeps callers authenticated by an API key away from routes
+// that manage API keys, whatever the key's scope.
+func (app *application) rejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requirePermission(code string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			permitted, err := app.userHasPermission(r, code)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if !permitted {
+				app.notPermittedResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+
+		return app.requireActivatedUser(fn)
+	}
+}
+
+func (app *application) requireSelfOrPermission(selfCode, code string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := app.readIDFromParam(r)
+
+			permitted, err := app.userMayActFor(r, id, selfCode, code)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if !permitted {
+				app.notPermittedResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+
+		return app.requireActivatedUser(fn)
+	}
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var key string
+
+		switch {
+		case app.contextGetAPIKey(r) != nil:
+			key = "api-key:" + strconv.FormatInt(app.contextGetAPIKey(r).ID, 10)
+		case !app.contextGetUser(r).IsAnonymous():
+			key = "user:" + strconv.FormatInt(app.contextGetUser(r).ID, 10)
+		default:
+			key = "ip:" + app.clientIP(r).String()
+		}
+
+		decision := app.limiter.Allow(key)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(decision.reset.Seconds())))
+
+		if !decision.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(decision.retryAfter.Seconds())))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type responseRecorder struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *responseRecorder) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *responseRecorder) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *responseRecorder) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+	return n, err
+}
+
+func (mw *responseRecorder) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+func (app *application) metrics(next http.Handler) http.Handler {
+	var (
+		totalRequestsReceived           = expvar.NewInt("total_requests_received")
+		totalResponsesSent              = expvar.NewInt("total_responses_sent")
+		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+		inFlightRequests                = expvar.NewInt("in_flight_requests")
+		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	)
+
+	requestDuration := app.registry.NewHistogramVec(
+		"http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route pattern.",
+		metrics.DefaultBuckets,
+		"route", "method", "status",
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		totalRequestsReceived.Add(1)
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+
+		mw := newResponseRecorder(w)
+
+		next.ServeHTTP(mw, r)
+
+		duration := time.Since(start)
+
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		// Label by the matched pattern rather than the raw path so IDs in the
+		// URL don't create a new series per record.
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		requestDuration.Observe(duration.Seconds(), route, metricMethod(r.Method), strconv.Itoa(mw.statusCode))
+	})
+}
+
+// metricMethod folds non-standard methods into one label value, since the
+// method comes straight from the client and each new value is a new series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestID(r, id)
+		r = r.WithContext(jsonlog.NewContext(r.Context(), app.logger.With(jsonlog.String("request_id", id))))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs from upstream proxies as long as they are short
+// and printable, so a client can't inject arbitrary bytes into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+type accessLogEntry struct {
+	userID int64
+}
+
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &accessLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		rec := newResponseRecorder(w)
+
+		defer func() {
+			fields := []jsonlog.Field{
+				jsonlog.String("request_method", r.Method),
+				jsonlog.String("request_url", r.URL.String()),
+				jsonlog.Int("status", rec.statusCode),
+				jsonlog.Int("bytes", rec.bytesWritten),
+				jsonlog.Duration("duration_ms", time.Since(start)),
+				jsonlog.String("remote_ip", app.clientIP(r).String()),
+			}
+
+			if entry.userID != 0 {
+				fields = append(fields, jsonlog.Int64("user_id", entry.userID))
+			}
+
+			jsonlog.FromContext(r.Context()).Info("request completed", fields...)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
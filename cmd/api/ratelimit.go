@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type rateLimitDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// rateLimiter is satisfied by anything that can make a token bucket decision
+// for a client key, so the in-memory store can be swapped for a shared one.
+type rateLimiter interface {
+	Allow(key string) rateLimitDecision
+	Peek(key string) rateLimitDecision
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   int
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newMemoryRateLimiter(rps float64, burst int) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		rps:     rps,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *memoryRateLimiter) Allow(key string) rateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+
+	decision := rateLimitDecision{limit: l.burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.allowed = true
+	} else {
+		decision.retryAfter = l.secondsUntil(1 - b.tokens)
+	}
+
+	decision.remaining = int(b.tokens)
+	decision.reset = l.secondsUntil(float64(l.burst) - b.tokens)
+
+	return decision
+}
+
+// Peek reports what Allow would decide without spending a token.
+func (l *memoryRateLimiter) Peek(key string) rateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tokens := float64(l.burst)
+
+	if b, exists := l.buckets[key]; exists {
+		tokens = math.Min(float64(l.burst), b.tokens+l.now().Sub(b.last).Seconds()*l.rps)
+	}
+
+	decision := rateLimitDecision{limit: l.burst, remaining: int(tokens), allowed: tokens >= 1}
+
+	if !decision.allowed {
+		decision.retryAfter = l.secondsUntil(1 - tokens)
+	}
+
+	decision.reset = l.secondsUntil(float64(l.burst) - tokens)
+
+	return decision
+}
+
+func (l *memoryRateLimiter) secondsUntil(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens/l.rps)) * time.Second
+}
+
+func (l *memoryRateLimiter) evictIdle(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if l.now().Sub(b.last) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rps float64, burst int) (*memoryRateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	l := newMemoryRateLimiter(rps, burst)
+	l.now = clock.Now
+
+	return l, clock
+}
+
+func TestMemoryRateLimiterBurst(t *testing.T) {
+	l, _ := newTestLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		d := l.Allow("client")
+		if !d.allowed {
+			t.Fatalf("request %d: got denied, want allowed within burst", i+1)
+		}
+		if want := 2 - i; d.remaining != want {
+			t.Errorf("request %d: got remaining %d, want %d", i+1, d.remaining, want)
+		}
+		if d.limit != 3 {
+			t.Errorf("request %d: got limit %d, want 3", i+1, d.limit)
+		}
+	}
+
+	d := l.Allow("client")
+	if d.allowed {
+		t.Fatal("got allowed past the burst, want denied")
+	}
+	if d.retryAfter != time.Second {
+		t.Errorf("got retryAfter %s, want 1s", d.retryAfter)
+	}
+	if d.reset != 3*time.Second {
+		t.Errorf("got reset %s, want 3s", d.reset)
+	}
+
+	if d := l.Allow("other"); !d.allowed {
+		t.Error("got denied for a different key, want each key to have its own bucket")
+	}
+}
+
+func TestMemoryRateLimiterRefill(t *testing.T) {
+	tests := []struct {
+		name          string
+		elapsed       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{"no time passed", 0, false, 0},
+		{"part of a token", 250 * time.Millisecond, false, 0},
+		{"one token", 500 * time.Millisecond, true, 0},
+		{"two tokens", time.Second, true, 1},
+		{"capped at burst", time.Minute, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter(2, 2)
+
+			l.Allow("client")
+			l.Allow("client")
+
+			clock.Advance(tt.elapsed)
+
+			d := l.Allow("client")
+			if d.allowed != tt.wantAllowed {
+				t.Errorf("got allowed %t, want %t", d.allowed, tt.wantAllowed)
+			}
+			if d.remaining != tt.wantRemaining {
+				t.Errorf("got remaining %d, want %d", d.remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimiterPeek(t *testing.T) {
+	l, clock := newTestLimiter(1, 2)
+
+	d := l.Peek("client")
+	if !d.allowed || d.remaining != 2 {
+		t.Fatalf("unseen key: got allowed %t remaining %d, want true 2", d.allowed, d.remaining)
+	}
+	if _, exists := l.buckets["client"]; exists {
+		t.Error("Peek created a bucket for an unseen key")
+	}
+
+	for i := 0; i < 5; i++ {
+		if d := l.Peek("client"); !d.allowed || d.remaining != 2 {
+			t.Fatalf("peek %d: got allowed %t remaining %d, want Peek not to spend tokens", i+1, d.allowed, d.remaining)
+		}
+	}
+
+	l.Allow("client")
+	l.Allow("client")
+
+	d = l.Peek("client")
+	if d.allowed {
+		t.Fatal("got allowed after the bucket was emptied, want denied")
+	}
+	if d.retryAfter != time.Second {
+		t.Errorf("got retryAfter %s, want 1s", d.retryAfter)
+	}
+
+	if allow := l.Allow("client"); allow.allowed != d.allowed || allow.remaining != d.remaining {
+		t.Errorf("Allow decided (%t, %d) after Peek decided (%t, %d)", allow.allowed, allow.remaining, d.allowed, d.remaining)
+	}
+
+	clock.Advance(time.Second)
+
+	if d := l.Peek("client"); !d.allowed || d.remaining != 1 {
+		t.Errorf("after refill: got allowed %t remaining %d, want true 1", d.allowed, d.remaining)
+	}
+}
+
+func TestMemoryRateLimiterEvictIdle(t *testing.T) {
+	l, clock := newTestLimiter(1, 1)
+
+	l.Allow("stale")
+	clock.Advance(2 * time.Minute)
+	l.Allow("fresh")
+	clock.Advance(2 * time.Minute)
+
+	l.evictIdle(3 * time.Minute)
+
+	if _, exists := l.buckets["stale"]; exists {
+		t.Error("stale bucket was not evicted")
+	}
+	if _, exists := l.buckets["fresh"]; !exists {
+		t.Error("fresh bucket was evicted")
+	}
+
+	if d := l.Allow("stale"); !d.allowed {
+		t.Error("got denied for an evicted key, want a full bucket")
+	}
+}
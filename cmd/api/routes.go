@@ -1,7 +1,9 @@
 package main
 
 import (
+	"expvar"
 	"github.com/go-chi/chi/v5"
+	"net/http"
 )
 
 func (app *application) routes() *chi.Mux {
@@ -10,13 +12,105 @@ func (app *application) routes() *chi.Mux {
 	router.NotFound(app.notFoundResponse)
 	router.MethodNotAllowed(app.methodNotAllowedResponse)
 
+	router.Use(app.requestID)
+	router.Use(app.logRequest)
+	router.Use(app.metrics)
+	router.Use(app.recoverPanic)
+
 	router.Route("/v1", func(r chi.Router) {
-		r.Get("/healthcheck", app.healthcheckHandler)
-		r.Post("/books", app.postBookHandler)
-		r.Get("/books/{id}", app.getBookHandler)
-		r.Put("/books/{id}", app.putBookHandler)
-		r.Delete("/books/{id}", app.deleteBookHandler)
-		r.Get("/books", app.getAllBooksHandler)
+		// Probes skip authentication and rate limiting so an orchestrator
+		// polling from a single address never gets a 429 for a healthy pod.
+		r.Get("/healthcheck/live", app.healthcheckHandler)
+		r.Get("/healthcheck/ready", app.readinessHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.authenticate)
+			r.Use(app.rateLimit)
+
+			r.Get("/healthcheck", app.healthcheckHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("books:read"))
+
+				r.Get("/books", app.getAllBooksHandler)
+				r.Get("/books/{id}", app.getBookHandler)
+				r.Get("/books/{id}/copies", app.getAllCopiesHandler)
+				r.Get("/books/{id}/copies/{copy_id}", app.getCopyHandler)
+				r.Get("/authors/{id}", app.getAuthorHandler)
+				r.Get("/genres", app.getAllGenresHandler)
+				r.Get("/genres/{id}", app.getGenreHandler)
+
+				r.Post("/books/{id}/holds", app.postHoldHandler)
+				r.Get("/holds/{id}", app.getHoldHandler)
+				r.Delete("/holds/{id}", app.deleteHoldHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("books:write"))
+
+				r.Post("/books", app.postBookHandler)
+				r.Put("/books/{id}", app.putBookHandler)
+				r.Patch("/books/{id}", app.patchBookHandler)
+				r.Delete("/books/{id}", app.deleteBookHandler)
+				r.Put("/books/{id}/authors", app.putBookAuthorsHandler)
+				r.Put("/books/{id}/genres", app.putBookGenresHandler)
+				r.Post("/books/{id}/copies", app.postCopyHandler)
+				r.Patch("/books/{id}/copies/{copy_id}", app.patchCopyHandler)
+				r.Delete("/books/{id}/copies/{copy_id}", app.deleteCopyHandler)
+
+				r.Post("/authors", app.postAuthorHandler)
+				r.Put("/authors/{id}", app.putAuthorHandler)
+				r.Delete("/authors/{id}", app.deleteAuthorHandler)
+
+				r.Post("/genres", app.postGenreHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("loans:manage"))
+
+				r.Get("/books/{id}/holds", app.getBookHoldsHandler)
+				r.Post("/users/borrow", app.borrowHandler)
+				r.Put("/users/return/{transaction_id}", app.returnHandler)
+				r.Post("/users/{id}/payments", app.postUserPaymentHandler)
+				r.Post("/users/{id}/waivers", app.postUserWaiverHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireSelfOrPermission("loans:self", "loans:manage"))
+
+				r.Get("/users/{id}/transactions", app.getUserTransactionsHandler)
+				r.Get("/users/{id}/balance", app.getUserBalanceHandler)
+			})
+
+			r.With(app.requireActivatedUser).Post("/loans/{id}/renew", app.renewLoanHandler)
+
+			r.With(app.requirePermission("users:admin")).Put("/users/{id}/roles", app.putUserRolesHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireActivatedUser)
+				r.Use(app.rejectAPIKeys)
+
+				r.Post("/api-keys", app.postAPIKeyHandler)
+				r.Get("/api-keys", app.getAllAPIKeysHandler)
+				r.Delete("/api-keys/{id}", app.deleteAPIKeyHandler)
+			})
+
+			r.Post("/users", app.registerUserHandler)
+			r.Put("/users/activated", app.activateUserHandler)
+			r.Put("/users/password", app.updateUserPasswordHandler)
+
+			r.With(app.throttleAuthFailures).Post("/tokens/authentication", app.createAuthenticationTokenHandler)
+			r.Post("/tokens/password-reset", app.createPasswordResetTokenHandler)
+		})
+	})
+
+	router.Group(func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.rateLimit)
+
+		r.Get("/.well-known/jwks.json", app.jwksHandler)
+		r.With(app.requirePermission("metrics:view")).Method(http.MethodGet, "/debug/vars", expvar.Handler())
+		r.With(app.requirePermission("metrics:view")).Method(http.MethodGet, "/metrics", app.registry.Handler())
 	})
 
 	return router
@@ -5,3 +5,5 @@ go 1.21.5
 require github.com/go-chi/chi/v5 v5.0.11
 
 require github.com/lib/pq v1.10.9
+
+require golang.org/x/crypto v0.17.0
@@ -1,7 +1,9 @@
 package data
 
 import (
+	"fmt"
 	"github.com/xuche123/bookwise/internal/validator"
+	"math"
 	"strings"
 )
 
@@ -10,6 +12,8 @@ type Filter struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       *Cursor
+	Fingerprint  string
 }
 
 func ValidateFilters(v *validator.Validator, f Filter) {
@@ -17,6 +21,11 @@ func ValidateFilters(v *validator.Validator, f Filter) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must not be greater than hundred")
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "does not match the sort value")
+		v.Check(f.Cursor.Fingerprint == f.Fingerprint, "cursor", "does not match the current filters")
+	}
 }
 
 func (f Filter) sortColumn() string {
@@ -39,5 +48,40 @@ func (f Filter) limit() int {
 	return f.PageSize
 }
 func (f Filter) offset() int {
+	if f.Cursor != nil {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+func (f Filter) seekPredicate(valueParam, idParam int) string {
+	operator := ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	return fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d))", f.sortColumn(), operator, valueParam, idParam)
+}
+
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/xuche123/bookwise/internal/validator"
+	"strings"
+	"time"
+)
+
+var AuthorRoles = []string{"author", "editor", "translator", "illustrator"}
+
+type Author struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Biography string    `json:"biography,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+type BookAuthor struct {
+	AuthorID int64  `json:"author_id"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role"`
+	Position int    `json:"position"`
+}
+
+func ValidateAuthor(v *validator.Validator, author *Author) {
+	v.Check(author.Name != "", "name", "must be provided")
+	v.Check(len(author.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(author.Biography) <= 50000, "biography", "must not be more than 50000 bytes long")
+}
+
+func ValidateBookAuthors(v *validator.Validator, credits []BookAuthor) {
+	v.Check(len(credits) > 0, "authors", "must contain at least one entry")
+
+	for _, credit := range credits {
+		v.Check(credit.AuthorID > 0, "authors", "must only contain valid author ids")
+		v.Check(validator.PermittedValue(credit.Role, AuthorRoles...), "authors", "must only contain roles author, editor, translator or illustrator")
+	}
+}
+
+type AuthorModel struct {
+	DB *sql.DB
+}
+
+func (m AuthorModel) Insert(author *Author) error {
+	query := `
+		INSERT INTO authors (name, biography)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err := m.DB.QueryRow(query, author.Name, author.Biography).Scan(&author.ID, &author.CreatedAt, &author.Version)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateAuthor
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m AuthorModel) Get(id int64) (*Author, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	query := `
+		SELECT id, name, coalesce(biography, ''), created_at, version
+		FROM authors
+		WHERE id = $1`
+
+	var author Author
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&author.ID,
+		&author.Name,
+		&author.Biography,
+		&author.CreatedAt,
+		&author.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	return &author, nil
+}
+
+func (m AuthorModel) Update(author *Author) error {
+	query := `
+		UPDATE authors
+		SET name = $1, biography = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{author.Name, author.Biography, author.ID, author.Version}
+
+	err := m.DB.QueryRow(query, args...).Scan(&author.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return editConflict()
+		case isUniqueViolation(err):
+			return ErrDuplicateAuthor
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m AuthorModel) Delete(id int64, version int32) error {
+	if id < 1 {
+		return recordNotFound()
+	}
+
+	query := `
+		DELETE FROM authors
+		WHERE id = $1 AND version = $2`
+
+	result, err := m.DB.Exec(query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return editConflict()
+	}
+
+	return nil
+}
+
+func (m AuthorModel) GetForBook(bookID int64) ([]BookAuthor, error) {
+	query := `
+		SELECT authors.id, authors.name, book_authors.role, book_authors.position
+		FROM book_authors
+		INNER JOIN authors ON authors.id = book_authors.author_id
+		WHERE book_authors.book_id = $1
+		ORDER BY book_authors.position, authors.id`
+
+	rows, err := m.DB.Query(query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []BookAuthor{}
+
+	for rows.Next() {
+		var credit BookAuthor
+
+		err := rows.Scan(&credit.AuthorID, &credit.Name, &credit.Role, &credit.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+func (m AuthorModel) SetForBook(book *Book, credits []BookAuthor) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE books
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	err = tx.QueryRow(query, book.ID, book.Version).Scan(&book.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return editConflict()
+		} else {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM book_authors WHERE book_id = $1`, book.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertBookAuthors(tx, book.ID, credits)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	book.Authors, err = m.GetForBook(book.ID)
+	return err
+}
+
+func insertBookAuthors(tx *sql.Tx, bookID int64, credits []BookAuthor) error {
+	query := `
+		INSERT INTO book_authors (book_id, author_id, role, position)
+		VALUES ($1, $2, $3, $4)`
+
+	for i, credit := range credits {
+		_, err := tx.Exec(query, bookID, credit.AuthorID, credit.Role, i+1)
+		if err != nil {
+			switch {
+			case isForeignKeyViolation(err):
+				return recordNotFound()
+			case isUniqueViolation(err):
+				return ErrDuplicateCredit
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// findOrCreateAuthor matches names the same way the authors migration
+// backfilled them, ignoring case, spacing and punctuation. The unique index
+// on that normalised name lets concurrent creates settle on a single row.
+func findOrCreateAuthor(tx *sql.Tx, name string) (int64, error) {
+	query := `
+		INSERT INTO authors (name)
+		VALUES ($1)
+		ON CONFLICT DO NOTHING
+		RETURNING id`
+
+	var id int64
+
+	err := tx.QueryRow(query, strings.TrimSpace(name)).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	query = `
+		SELECT id
+		FROM authors
+		WHERE regexp_replace(lower(name), '[^[:alnum:]]', '', 'g') = regexp_replace(lower($1), '[^[:alnum:]]', '', 'g')`
+
+	err = tx.QueryRow(query, name).Scan(&id)
+	return id, err
+}
+
+// replaceAuthorCredit points a book's "author" credits at the author row
+// matching name, leaving its other roles alone.
+func replaceAuthorCredit(tx *sql.Tx, bookID int64, name string) error {
+	authorID, err := findOrCreateAuthor(tx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM book_authors WHERE book_id = $1 AND role = 'author'`, bookID)
+	if err != nil {
+		return err
+	}
+
+	return insertBookAuthors(tx, bookID, []BookAuthor{{AuthorID: authorID, Role: "author"}})
+}
@@ -5,17 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/xuche123/bookwise/internal/validator"
+	"strconv"
 	"time"
 )
 
 type Book struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	Author      string    `json:"author"`
-	ImageURL    string    `json:"image_url,omitempty"`
-	Description string    `json:"description,omitempty"`
-	CreatedAt   time.Time `json:"-"`
-	Version     int32     `json:"version"`
+	ID           int64         `json:"id"`
+	Title        string        `json:"title"`
+	Author       string        `json:"author"`
+	ImageURL     string        `json:"image_url,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	Authors      []BookAuthor  `json:"authors,omitempty"`
+	Genres       []*Genre      `json:"genres,omitempty"`
+	Availability *Availability `json:"availability,omitempty"`
+	CreatedAt    time.Time     `json:"-"`
+	Version      int32         `json:"version"`
 }
 
 func ValidateBook(v *validator.Validator, book *Book) {
@@ -33,7 +37,16 @@ type BookModel struct {
 	DB *sql.DB
 }
 
-func (m BookModel) Insert(book *Book) error {
+// Insert creates the book and its author credits in one transaction. With no
+// credits, the free-text author is matched to an existing author row, or a
+// new one is created, and credited as the book's author.
+func (m BookModel) Insert(book *Book, credits []BookAuthor) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO books (title, author, image_url, description) 
 		VALUES ($1, $2, $3, $4)
@@ -41,12 +54,37 @@ func (m BookModel) Insert(book *Book) error {
 
 	args := []any{book.Title, book.Author, book.ImageURL, book.Description}
 
-	return m.DB.QueryRow(query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	err = tx.QueryRow(query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	if err != nil {
+		return err
+	}
+
+	if len(credits) == 0 {
+		authorID, err := findOrCreateAuthor(tx, book.Author)
+		if err != nil {
+			return err
+		}
+
+		credits = []BookAuthor{{AuthorID: authorID, Role: "author"}}
+	}
+
+	err = insertBookAuthors(tx, book.ID, credits)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	book.Authors, err = AuthorModel{DB: m.DB}.GetForBook(book.ID)
+	return err
 }
 
 func (m BookModel) Get(id int64) (*Book, error) {
 	if id < 1 {
-		return nil, ErrRecordNotFound
+		return nil, recordNotFound()
 	}
 
 	query := `
@@ -68,7 +106,7 @@ func (m BookModel) Get(id int64) (*Book, error) {
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrRecordNotFound
+			return nil, recordNotFound()
 		} else {
 			return nil, err
 		}
@@ -77,7 +115,27 @@ func (m BookModel) Get(id int64) (*Book, error) {
 	return &book, nil
 }
 
+// Update saves the book's fields. When the free-text author changes, its
+// "author" credits are re-derived from the new name in the same transaction
+// so the two never disagree.
 func (m BookModel) Update(book *Book) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previousAuthor string
+
+	err = tx.QueryRow(`SELECT author FROM books WHERE id = $1 AND version = $2 FOR UPDATE`, book.ID, book.Version).Scan(&previousAuthor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return editConflict()
+		} else {
+			return err
+		}
+	}
+
 	query := `
 		UPDATE books
 		SET title = $1, author = $2, image_url = $3, description = $4, version = version + 1
@@ -86,30 +144,35 @@ func (m BookModel) Update(book *Book) error {
 
 	args := []any{book.Title, book.Author, book.ImageURL, book.Description, book.ID, book.Version}
 
-	err := m.DB.QueryRow(query, args...).Scan(&book.Version)
-
+	err = tx.QueryRow(query, args...).Scan(&book.Version)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrEditConflict
-		} else {
+		return err
+	}
+
+	if book.Author != previousAuthor {
+		err = replaceAuthorCredit(tx, book.ID, book.Author)
+		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (m BookModel) Delete(id int64) error {
+func (m BookModel) Delete(id int64, version int32) error {
 	if id < 1 {
-		return ErrRecordNotFound
+		return recordNotFound()
 	}
 
 	query := `
 		DELETE FROM books
-		WHERE id = $1`
+		WHERE id = $1 AND version = $2`
 
-	result, err := m.DB.Exec(query, id)
+	result, err := m.DB.Exec(query, id, version)
 	if err != nil {
+		if isForeignKeyViolationOn(err, "loans_copy_id_fkey") {
+			return ErrHasLoanHistory
+		}
 		return err
 	}
 
@@ -118,26 +181,58 @@ func (m BookModel) Delete(id int64) error {
 		return err
 	}
 
+	// Nothing matched either because the version moved on or because the
+	// book was deleted in the meantime; only the first is an edit conflict.
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		var exists bool
+
+		err = m.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM books WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return recordNotFound()
+		}
+
+		return editConflict()
 	}
 
 	return nil
 }
 
-func (m BookModel) GetAll(title string, author string, filter Filter) ([]*Book, error) {
+func (m BookModel) GetAll(title string, author string, genreID int64, filter Filter) ([]*Book, Metadata, error) {
+	seek := "TRUE"
+	total := "count(*) OVER()"
+	args := []any{title, author, filter.limit(), filter.offset(), genreID}
+
+	// Keyset pages skip the window count, which would scan the whole filtered
+	// set on every page; cursor metadata doesn't report a total anyway.
+	if filter.Cursor != nil {
+		seek = filter.seekPredicate(6, 7)
+		total = "0"
+		args = append(args, filter.Cursor.Value, filter.Cursor.ID)
+	}
+
 	query := fmt.Sprintf(`
-		SELECT id, title, author, image_url, description, created_at, version
+		WITH RECURSIVE genre_tree AS (
+			SELECT id FROM genres WHERE id = $5
+			UNION ALL
+			SELECT genres.id FROM genres INNER JOIN genre_tree ON genres.parent_id = genre_tree.id
+		)
+		SELECT %s, id, title, author, image_url, description, created_at, version
 		FROM books
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (to_tsvector('simple', author) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND ($5 = 0 OR id IN (SELECT book_id FROM book_genres WHERE genre_id IN (SELECT id FROM genre_tree)))
+		AND %s
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filter.sortColumn(), filter.sortDirection())
+		LIMIT $3 OFFSET $4`, total, seek, filter.sortColumn(), filter.sortDirection())
 
-	rows, err := m.DB.Query(query, title, author, filter.limit(), filter.offset())
+	rows, err := m.DB.Query(query, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer func(rows *sql.Rows) {
@@ -147,12 +242,14 @@ func (m BookModel) GetAll(title string, author string, filter Filter) ([]*Book,
 		}
 	}(rows)
 
+	totalRecords := 0
 	books := []*Book{}
 
 	for rows.Next() {
 		var book Book
 
 		err := rows.Scan(
+			&totalRecords,
 			&book.ID,
 			&book.Title,
 			&book.Author,
@@ -163,14 +260,76 @@ func (m BookModel) GetAll(title string, author string, filter Filter) ([]*Book,
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		books = append(books, &book)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filter.Page, filter.PageSize)
+	if filter.Cursor != nil {
+		metadata = Metadata{PageSize: filter.PageSize}
 	}
 
-	return books, nil
+	return books, metadata, nil
+}
+
+func NextBookCursor(filter Filter, books []*Book) *Cursor {
+	if len(books) == 0 || len(books) < filter.PageSize {
+		return nil
+	}
+
+	last := books[len(books)-1]
+	cursor := &Cursor{Sort: filter.Sort, Fingerprint: filter.Fingerprint, ID: last.ID}
+
+	switch filter.sortColumn() {
+	case "title":
+		cursor.Value = last.Title
+	case "author":
+		cursor.Value = last.Author
+	case "created_at":
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = strconv.FormatInt(last.ID, 10)
+	}
+
+	return cursor
+}
+
+// lockBook takes the row lock that every change to a book's copies, loans
+// and hold queue goes through, so a hold being placed and a copy coming back
+// can't miss each other. Take it before locking any copy or hold rows.
+func lockBook(tx *sql.Tx, bookID int64) error {
+	query := `
+		SELECT id
+		FROM books
+		WHERE id = $1
+		FOR UPDATE`
+
+	err := tx.QueryRow(query, bookID).Scan(&bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return recordNotFound()
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// touchCirculation bumps the book's circulation version when its copies or
+// loans change. It is kept apart from version so that lending a copy doesn't
+// invalidate If-Match on bibliographic edits.
+func touchCirculation(tx *sql.Tx, bookID int64) error {
+	query := `
+		UPDATE books
+		SET circulation_version = circulation_version + 1
+		WHERE id = $1`
+
+	_, err := tx.Exec(query, bookID)
+	return err
 }
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/xuche123/bookwise/internal/validator"
+	"time"
+)
+
+const (
+	CopyStatusAvailable = "available"
+	CopyStatusOnLoan    = "on-loan"
+	CopyStatusLost      = "lost"
+	CopyStatusInRepair  = "in-repair"
+	CopyStatusWithdrawn = "withdrawn"
+)
+
+var (
+	CopyStatuses   = []string{CopyStatusAvailable, CopyStatusOnLoan, CopyStatusLost, CopyStatusInRepair, CopyStatusWithdrawn}
+	CopyConditions = []string{"new", "good", "fair", "poor", "damaged"}
+)
+
+type Copy struct {
+	ID            int64     `json:"id"`
+	BookID        int64     `json:"book_id"`
+	Barcode       string    `json:"barcode"`
+	Branch        string    `json:"branch"`
+	ShelfLocation string    `json:"shelf_location,omitempty"`
+	Condition     string    `json:"condition"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"-"`
+	Version       int32     `json:"version"`
+}
+
+// Availability counts a book's copies by status. Available copies set aside
+// for a ready hold are counted as OnHold, since nobody else can borrow them.
+type Availability struct {
+	Total     int   `json:"total"`
+	Available int   `json:"available"`
+	OnHold    int   `json:"on_hold"`
+	OnLoan    int   `json:"on_loan"`
+	Lost      int   `json:"lost"`
+	InRepair  int   `json:"in_repair"`
+	Withdrawn int   `json:"withdrawn"`
+	Version   int32 `json:"-"`
+}
+
+func ValidateCopy(v *validator.Validator, bookCopy *Copy) {
+	v.Check(bookCopy.Barcode != "", "barcode", "must be provided")
+	v.Check(len(bookCopy.Barcode) <= 100, "barcode", "must not be more than 100 bytes long")
+	v.Check(bookCopy.Branch != "", "branch", "must be provided")
+	v.Check(len(bookCopy.Branch) <= 200, "branch", "must not be more than 200 bytes long")
+	v.Check(len(bookCopy.ShelfLocation) <= 200, "shelf_location", "must not be more than 200 bytes long")
+	v.Check(validator.PermittedValue(bookCopy.Condition, CopyConditions...), "condition", "must be one of new, good, fair, poor or damaged")
+	v.Check(validator.PermittedValue(bookCopy.Status, CopyStatuses...), "status", "must be one of available, on-loan, lost, in-repair or withdrawn")
+}
+
+type CopyModel struct {
+	DB *sql.DB
+}
+
+func (m CopyModel) Insert(bookCopy *Copy) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO copies (book_id, barcode, branch, shelf_location, condition, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	args := []any{bookCopy.BookID, bookCopy.Barcode, bookCopy.Branch, bookCopy.ShelfLocation, bookCopy.Condition, bookCopy.Status}
+
+	err = tx.QueryRow(query, args...).Scan(&bookCopy.ID, &bookCopy.CreatedAt, &bookCopy.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateBarcode
+		case isForeignKeyViolation(err):
+			return recordNotFound()
+		default:
+			return err
+		}
+	}
+
+	err = touchCirculation(tx, bookCopy.BookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CopyModel) Get(bookID, id int64) (*Copy, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	query := `
+		SELECT id, book_id, barcode, branch, shelf_location, condition, status, created_at, version
+		FROM copies
+		WHERE id = $1 AND book_id = $2`
+
+	var bookCopy Copy
+
+	err := m.DB.QueryRow(query, id, bookID).Scan(
+		&bookCopy.ID,
+		&bookCopy.BookID,
+		&bookCopy.Barcode,
+		&bookCopy.Branch,
+		&bookCopy.ShelfLocation,
+		&bookCopy.Condition,
+		&bookCopy.Status,
+		&bookCopy.CreatedAt,
+		&bookCopy.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	return &bookCopy, nil
+}
+
+func (m CopyModel) GetAllForBook(bookID int64) ([]*Copy, error) {
+	query := `
+		SELECT id, book_id, barcode, branch, shelf_location, condition, status, created_at, version
+		FROM copies
+		WHERE book_id = $1
+		ORDER BY branch, shelf_location, id`
+
+	rows, err := m.DB.Query(query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []*Copy{}
+
+	for rows.Next() {
+		var bookCopy Copy
+
+		err := rows.Scan(
+			&bookCopy.ID,
+			&bookCopy.BookID,
+			&bookCopy.Barcode,
+			&bookCopy.Branch,
+			&bookCopy.ShelfLocation,
+			&bookCopy.Condition,
+			&bookCopy.Status,
+			&bookCopy.CreatedAt,
+			&bookCopy.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		copies = append(copies, &bookCopy)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+func (m CopyModel) Update(bookCopy *Copy) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE copies
+		SET barcode = $1, branch = $2, shelf_location = $3, condition = $4, status = $5, version = version + 1
+		WHERE id = $6 AND version = $7
+		RETURNING version`
+
+	args := []any{bookCopy.Barcode, bookCopy.Branch, bookCopy.ShelfLocation, bookCopy.Condition, bookCopy.Status, bookCopy.ID, bookCopy.Version}
+
+	err = tx.QueryRow(query, args...).Scan(&bookCopy.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return editConflict()
+		case isUniqueViolation(err):
+			return ErrDuplicateBarcode
+		default:
+			return err
+		}
+	}
+
+	err = touchCirculation(tx, bookCopy.BookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CopyModel) Delete(bookCopy *Copy) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM copies
+		WHERE id = $1 AND version = $2`
+
+	result, err := tx.Exec(query, bookCopy.ID, bookCopy.Version)
+	if err != nil {
+		if isForeignKeyViolationOn(err, "loans_copy_id_fkey") {
+			return ErrHasLoanHistory
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return editConflict()
+	}
+
+	err = touchCirculation(tx, bookCopy.BookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CopyModel) AvailabilityForBook(bookID int64) (*Availability, error) {
+	query := `
+		SELECT count(*),
+			count(*) FILTER (WHERE status = 'available' AND NOT held),
+			count(*) FILTER (WHERE status = 'available' AND held),
+			count(*) FILTER (WHERE status = 'on-loan'),
+			count(*) FILTER (WHERE status = 'lost'),
+			count(*) FILTER (WHERE status = 'in-repair'),
+			count(*) FILTER (WHERE status = 'withdrawn'),
+			(SELECT circulation_version FROM books WHERE id = $1)
+		FROM (
+			SELECT status, EXISTS (SELECT 1 FROM holds WHERE holds.copy_id = copies.id AND holds.status = 'ready') AS held
+			FROM copies
+			WHERE book_id = $1
+		) AS copies`
+
+	var availability Availability
+
+	err := m.DB.QueryRow(query, bookID).Scan(
+		&availability.Total,
+		&availability.Available,
+		&availability.OnHold,
+		&availability.OnLoan,
+		&availability.Lost,
+		&availability.InRepair,
+		&availability.Withdrawn,
+		&availability.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &availability, nil
+}
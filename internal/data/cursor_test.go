@@ -0,0 +1,146 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/xuche123/bookwise/internal/validator"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCursorSecret = []byte("test-cursor-secret")
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{Sort: "-title", Fingerprint: Fingerprint("dune", "", "0"), Value: "Dune", ID: 42}
+
+	got, err := DecodeCursor(c.Encode(testCursorSecret), testCursorSecret)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+
+	if *got != c {
+		t.Errorf("got %+v, want %+v", *got, c)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	valid := Cursor{Sort: "id", Value: "10", ID: 10}.Encode(testCursorSecret)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	forged := Cursor{Sort: "id", Value: "99", ID: 99}.Encode([]byte("another-secret"))
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"signature not base64", payload + ".!!!"},
+		{"wrong secret", forged},
+		{"payload swapped", forgedPayload + "." + signature},
+		{"truncated signature", payload + "." + signature[:len(signature)-2]},
+		{"payload not json", "bm90IGpzb24." + sign("bm90IGpzb24")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCursor(tt.cursor, testCursorSecret)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got error %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func sign(encoded string) string {
+	return base64.RawURLEncoding.EncodeToString(cursorSignature(encoded, testCursorSecret))
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("a", "b") != Fingerprint("a", "b") {
+		t.Error("same values gave different fingerprints")
+	}
+
+	if Fingerprint("ab", "") == Fingerprint("a", "b") {
+		t.Error("values are not separated, so shifting a byte between them gave the same fingerprint")
+	}
+
+	if Fingerprint("dune", "", "0") == Fingerprint("dune", "", "3") {
+		t.Error("different filters gave the same fingerprint")
+	}
+}
+
+func TestValidateFiltersCursorBinding(t *testing.T) {
+	fingerprint := Fingerprint("dune", "", "0")
+
+	tests := []struct {
+		name   string
+		cursor *Cursor
+		sort   string
+		valid  bool
+	}{
+		{"no cursor", nil, "title", true},
+		{"matching cursor", &Cursor{Sort: "title", Fingerprint: fingerprint}, "title", true},
+		{"different sort", &Cursor{Sort: "-title", Fingerprint: fingerprint}, "title", false},
+		{"different filters", &Cursor{Sort: "title", Fingerprint: Fingerprint("dune", "herbert", "0")}, "title", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			ValidateFilters(v, Filter{
+				Page:         1,
+				PageSize:     20,
+				Sort:         tt.sort,
+				SortSafeList: []string{"title", "-title"},
+				Cursor:       tt.cursor,
+				Fingerprint:  fingerprint,
+			})
+
+			if v.Valid() != tt.valid {
+				t.Errorf("got valid %t, want %t (errors: %v)", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+}
+
+func TestNextBookCursor(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []*Book{
+		{ID: 1, Title: "Emma", Author: "Austen", CreatedAt: created},
+		{ID: 2, Title: "Dune", Author: "Herbert", CreatedAt: created.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		sort  string
+		value string
+	}{
+		{"id", "2"},
+		{"-title", "Dune"},
+		{"author", "Herbert"},
+		{"created_at", created.Add(time.Hour).Format(time.RFC3339Nano)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			filter := Filter{PageSize: 2, Sort: tt.sort, SortSafeList: []string{tt.sort}, Fingerprint: "fp"}
+
+			c := NextBookCursor(filter, books)
+			if c == nil {
+				t.Fatal("got no cursor for a full page")
+			}
+
+			want := Cursor{Sort: tt.sort, Fingerprint: "fp", Value: tt.value, ID: 2}
+			if *c != want {
+				t.Errorf("got %+v, want %+v", *c, want)
+			}
+		})
+	}
+
+	if c := NextBookCursor(Filter{PageSize: 3, Sort: "id", SortSafeList: []string{"id"}}, books); c != nil {
+		t.Errorf("got cursor %+v for a short page, want none", *c)
+	}
+}
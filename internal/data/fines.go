@@ -0,0 +1,289 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/xuche123/bookwise/internal/validator"
+	"time"
+)
+
+const (
+	LedgerKindCharge  = "charge"
+	LedgerKindPayment = "payment"
+	LedgerKindWaiver  = "waiver"
+)
+
+type FinePolicy struct {
+	DailyCents      int64
+	CapCents        int64
+	GraceDays       int
+	MaxBalanceCents int64
+}
+
+type LedgerEntry struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	LoanID      *int64    `json:"loan_id,omitempty"`
+	Kind        string    `json:"kind"`
+	AmountCents int64     `json:"amount_cents"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Balance is the ledger as it stands. PendingCents is what late loans have
+// accrued since they were last charged; it is not part of BalanceCents until
+// the next accrual writes it to the ledger.
+type Balance struct {
+	UserID       int64          `json:"user_id"`
+	BalanceCents int64          `json:"balance_cents"`
+	PendingCents int64          `json:"pending_cents"`
+	Entries      []*LedgerEntry `json:"entries"`
+}
+
+func ValidateCredit(v *validator.Validator, entry *LedgerEntry) {
+	v.Check(entry.AmountCents > 0, "amount_cents", "must be greater than zero")
+	v.Check(len(entry.Description) <= 500, "description", "must not be more than 500 bytes long")
+}
+
+type FineModel struct {
+	DB *sql.DB
+}
+
+// overdueLoans selects each late loan with what the policy says it owes and
+// what has already been charged against it. $1 to $3 are the cap, the daily
+// rate and the grace days; callers append their own conditions.
+const overdueLoans = `
+	SELECT loans.id, loans.user_id,
+		LEAST($1::bigint, $2::bigint * GREATEST(0, floor(extract(epoch FROM coalesce(loans.returned_at, NOW()) - loans.due_at) / 86400)::bigint - $3::int)) AS owed,
+		(SELECT coalesce(sum(amount_cents), 0) FROM fine_ledger WHERE fine_ledger.loan_id = loans.id AND kind = 'charge') AS charged
+	FROM loans
+	WHERE coalesce(loans.returned_at, NOW()) > loans.due_at`
+
+// Accrue charges whatever a user's late loans owe beyond what has already
+// been charged.
+func (m FineModel) Accrue(policy FinePolicy, userID int64) (int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockLedger(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	charged, err := accrue(tx, policy, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return charged, tx.Commit()
+}
+
+// AccrueAll finds the users with uncharged fines and accrues each of them in
+// their own transaction, so the sweep never holds more than one user's lock.
+func (m FineModel) AccrueAll(policy FinePolicy) (int64, error) {
+	query := `
+		SELECT DISTINCT user_id
+		FROM (` + overdueLoans + `) AS overdue
+		WHERE owed > charged`
+
+	rows, err := m.DB.Query(query, policy.CapCents, policy.DailyCents, policy.GraceDays)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return 0, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+
+	for _, userID := range userIDs {
+		charged, err := m.Accrue(policy, userID)
+		if err != nil {
+			return total, err
+		}
+
+		total += charged
+	}
+
+	return total, nil
+}
+
+func accrue(tx *sql.Tx, policy FinePolicy, userID int64) (int64, error) {
+	query := `
+		INSERT INTO fine_ledger (user_id, loan_id, kind, amount_cents, description)
+		SELECT user_id, id, 'charge', owed - charged, 'overdue fine'
+		FROM (` + overdueLoans + `
+			AND loans.user_id = $4
+		) AS overdue
+		WHERE owed > charged`
+
+	result, err := tx.Exec(query, policy.CapCents, policy.DailyCents, policy.GraceDays, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m FineModel) GetBalance(policy FinePolicy, userID int64) (*Balance, error) {
+	query := `
+		SELECT id, user_id, loan_id, kind, amount_cents, description, created_at
+		FROM fine_ledger
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := &Balance{UserID: userID, Entries: []*LedgerEntry{}}
+
+	for rows.Next() {
+		var entry LedgerEntry
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&entry.LoanID,
+			&entry.Kind,
+			&entry.AmountCents,
+			&entry.Description,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		balance.BalanceCents += entry.AmountCents
+		balance.Entries = append(balance.Entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT coalesce(sum(owed - charged), 0)
+		FROM (` + overdueLoans + `
+			AND loans.user_id = $4
+		) AS overdue
+		WHERE owed > charged`
+
+	err = m.DB.QueryRow(query, policy.CapCents, policy.DailyCents, policy.GraceDays, userID).Scan(&balance.PendingCents)
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// Credit records a payment or waiver. The balance is read under the ledger
+// lock so concurrent credits can't both pass the check and overpay, and a
+// loan_id must belong to the user being credited.
+func (m FineModel) Credit(entry *LedgerEntry) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockLedger(tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	if entry.LoanID != nil {
+		var loanUserID int64
+
+		err = tx.QueryRow(`SELECT user_id FROM loans WHERE id = $1`, *entry.LoanID).Scan(&loanUserID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return recordNotFound()
+		case err != nil:
+			return err
+		case loanUserID != entry.UserID:
+			return recordNotFound()
+		}
+	}
+
+	balance, err := ledgerBalance(tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	if entry.AmountCents > balance {
+		return ErrCreditExceedsOwed
+	}
+
+	query := `
+		INSERT INTO fine_ledger (user_id, loan_id, kind, amount_cents, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{entry.UserID, entry.LoanID, entry.Kind, -entry.AmountCents, entry.Description}
+
+	err = tx.QueryRow(query, args...).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		switch {
+		case isForeignKeyViolationOn(err, "fine_ledger_user_id_fkey"):
+			return ErrUnknownUser
+		case isForeignKeyViolation(err):
+			return recordNotFound()
+		default:
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	entry.AmountCents = -entry.AmountCents
+
+	return nil
+}
+
+func ledgerBalance(tx *sql.Tx, userID int64) (int64, error) {
+	query := `
+		SELECT coalesce(sum(amount_cents), 0)
+		FROM fine_ledger
+		WHERE user_id = $1`
+
+	var balance int64
+
+	err := tx.QueryRow(query, userID).Scan(&balance)
+	return balance, err
+}
+
+// ledgerLockClass keeps the ledger's advisory locks apart from any others
+// taken on the same database.
+const ledgerLockClass = 1
+
+// lockLedger serialises accrual, credits and the borrowing check for one
+// user for the rest of the transaction. Ids past 2^31 wrap and may share a
+// lock with another user, which only costs some extra waiting.
+func lockLedger(tx *sql.Tx, userID int64) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, ledgerLockClass, int32(userID))
+	return err
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/xuche123/bookwise/internal/validator"
+	"time"
+)
+
+type Genre struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+type GenreCount struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	if genre.ParentID != nil {
+		v.Check(*genre.ParentID > 0, "parent_id", "must be a valid genre id")
+	}
+}
+
+func ValidateGenreIDs(v *validator.Validator, ids []int64) {
+	for _, id := range ids {
+		v.Check(id > 0, "genres", "must only contain valid genre ids")
+	}
+	v.Check(validator.Unique(ids), "genres", "must not contain duplicate values")
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (name, parent_id)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err := m.DB.QueryRow(query, genre.Name, genre.ParentID).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return recordNotFound()
+		case isUniqueViolation(err):
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	query := `
+		SELECT id, name, parent_id, created_at, version
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&genre.ID,
+		&genre.Name,
+		&genre.ParentID,
+		&genre.CreatedAt,
+		&genre.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT id, name, parent_id, created_at, version
+		FROM genres
+		ORDER BY coalesce(parent_id, 0), name, id`
+
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.Name,
+			&genre.ParentID,
+			&genre.CreatedAt,
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) GetForBook(bookID int64) ([]*Genre, error) {
+	query := `
+		SELECT genres.id, genres.name, genres.parent_id, genres.created_at, genres.version
+		FROM book_genres
+		INNER JOIN genres ON genres.id = book_genres.genre_id
+		WHERE book_genres.book_id = $1
+		ORDER BY genres.name, genres.id`
+
+	rows, err := m.DB.Query(query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.Name,
+			&genre.ParentID,
+			&genre.CreatedAt,
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) SetForBook(book *Book, genreIDs []int64) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE books
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	err = tx.QueryRow(query, book.ID, book.Version).Scan(&book.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return editConflict()
+		} else {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM book_genres WHERE book_id = $1`, book.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO book_genres (book_id, genre_id)
+		SELECT $1, unnest($2::bigint[])`
+
+	_, err = tx.Exec(query, book.ID, pq.Array(genreIDs))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return recordNotFound()
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	book.Genres, err = m.GetForBook(book.ID)
+	return err
+}
+
+func (m GenreModel) CountsForBooks(books []*Book) ([]GenreCount, error) {
+	ids := make([]int64, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+
+	// Each tag is walked up to its ancestors so a parent genre's count
+	// includes books filed under its subgenres, matching the genre filter.
+	// UNION drops repeated pairs, so a book tagged with both a parent and
+	// its child is counted once.
+	query := `
+		WITH RECURSIVE tagged AS (
+			SELECT book_genres.book_id, book_genres.genre_id
+			FROM book_genres
+			WHERE book_genres.book_id = ANY($1)
+			UNION
+			SELECT tagged.book_id, genres.parent_id
+			FROM tagged
+			INNER JOIN genres ON genres.id = tagged.genre_id
+			WHERE genres.parent_id IS NOT NULL
+		)
+		SELECT genres.id, genres.name, count(*)
+		FROM tagged
+		INNER JOIN genres ON genres.id = tagged.genre_id
+		GROUP BY genres.id, genres.name
+		ORDER BY count(*) DESC, genres.name`
+
+	rows, err := m.DB.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []GenreCount{}
+
+	for rows.Next() {
+		var count GenreCount
+
+		err := rows.Scan(&count.ID, &count.Name, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SchemaVersion is the number of the newest migration in ./migrations. Bump
+// it alongside every new migration so readiness checks catch unmigrated
+// databases.
+const SchemaVersion = 17
+
+type HealthModel struct {
+	DB *sql.DB
+}
+
+func (m HealthModel) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+func (m HealthModel) Stats() sql.DBStats {
+	return m.DB.Stats()
+}
+
+// MigrationVersion reads the version recorded by migrate in
+// schema_migrations. dirty is true when a migration failed part way.
+func (m HealthModel) MigrationVersion(timeout time.Duration) (version int64, dirty bool, err error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,425 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+const (
+	HoldStatusWaiting   = "waiting"
+	HoldStatusReady     = "ready"
+	HoldStatusFulfilled = "fulfilled"
+	HoldStatusExpired   = "expired"
+	HoldStatusCancelled = "cancelled"
+)
+
+type Hold struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	BookID    int64      `json:"book_id"`
+	CopyID    *int64     `json:"copy_id,omitempty"`
+	Status    string     `json:"status"`
+	Position  int        `json:"queue_position,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Version   int32      `json:"version"`
+}
+
+type HoldModel struct {
+	DB *sql.DB
+}
+
+func (m HoldModel) Place(userID, bookID int64) (*Hold, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockBook(tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var available bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM copies
+			WHERE book_id = $1 AND status = 'available'
+			AND NOT EXISTS (SELECT 1 FROM holds WHERE holds.copy_id = copies.id AND holds.status = 'ready')
+		)`
+
+	err = tx.QueryRow(query, bookID).Scan(&available)
+	if err != nil {
+		return nil, err
+	}
+
+	if available {
+		return nil, ErrCopyAvailable
+	}
+
+	hold := &Hold{UserID: userID, BookID: bookID}
+
+	query = `
+		INSERT INTO holds (user_id, book_id)
+		VALUES ($1, $2)
+		RETURNING id, status, created_at, version`
+
+	err = tx.QueryRow(query, userID, bookID).Scan(&hold.ID, &hold.Status, &hold.CreatedAt, &hold.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return nil, ErrDuplicateHold
+		case isForeignKeyViolationOn(err, "holds_user_id_fkey"):
+			return nil, ErrUnknownUser
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Position, err = m.position(hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (m HoldModel) Get(id int64) (*Hold, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	query := `
+		SELECT id, user_id, book_id, copy_id, status, created_at, ready_at, expires_at, version
+		FROM holds
+		WHERE id = $1`
+
+	var hold Hold
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&hold.ID,
+		&hold.UserID,
+		&hold.BookID,
+		&hold.CopyID,
+		&hold.Status,
+		&hold.CreatedAt,
+		&hold.ReadyAt,
+		&hold.ExpiresAt,
+		&hold.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	hold.Position, err = m.position(&hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+func (m HoldModel) GetQueueForBook(bookID int64) ([]*Hold, error) {
+	query := `
+		SELECT id, user_id, book_id, copy_id, status, created_at, ready_at, expires_at, version,
+			CASE WHEN status = 'waiting' THEN row_number() OVER (PARTITION BY status ORDER BY id) ELSE 0 END
+		FROM holds
+		WHERE book_id = $1 AND status IN ('waiting', 'ready')
+		ORDER BY status = 'waiting', id`
+
+	rows, err := m.DB.Query(query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*Hold{}
+
+	for rows.Next() {
+		var hold Hold
+
+		err := rows.Scan(
+			&hold.ID,
+			&hold.UserID,
+			&hold.BookID,
+			&hold.CopyID,
+			&hold.Status,
+			&hold.CreatedAt,
+			&hold.ReadyAt,
+			&hold.ExpiresAt,
+			&hold.Version,
+			&hold.Position,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+func (m HoldModel) Cancel(hold *Hold, pickupWindow time.Duration) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockBook(tx, hold.BookID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE holds
+		SET status = 'cancelled', version = version + 1
+		WHERE id = $1 AND version = $2 AND status IN ('waiting', 'ready')
+		RETURNING status, version`
+
+	err = tx.QueryRow(query, hold.ID, hold.Version).Scan(&hold.Status, &hold.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return editConflict()
+		} else {
+			return err
+		}
+	}
+
+	if hold.CopyID != nil {
+		err = assignCopyToNextHold(tx, hold.BookID, *hold.CopyID, pickupWindow)
+		if err != nil {
+			return err
+		}
+
+		err = touchCirculation(tx, hold.BookID)
+		if err != nil {
+			return err
+		}
+	}
+
+	hold.Position = 0
+
+	return tx.Commit()
+}
+
+// ExpireLapsed expires ready holds whose pickup window has passed and offers
+// their copies to the next patron in line. Books are locked in id order so
+// concurrent sweeps can't deadlock on each other.
+func (m HoldModel) ExpireLapsed(pickupWindow time.Duration) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT DISTINCT book_id
+		FROM holds
+		WHERE status = 'ready' AND expires_at < NOW()
+		ORDER BY book_id`
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var bookIDs []int64
+
+	for rows.Next() {
+		var bookID int64
+
+		err := rows.Scan(&bookID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		bookIDs = append(bookIDs, bookID)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+
+	for _, bookID := range bookIDs {
+		err = lockBook(tx, bookID)
+		if err != nil {
+			return 0, err
+		}
+
+		query = `
+			WITH lapsed AS (
+				UPDATE holds
+				SET status = 'expired', version = version + 1
+				WHERE book_id = $1 AND status = 'ready' AND expires_at < NOW()
+				RETURNING copy_id
+			)
+			SELECT count(*), coalesce(array_agg(copy_id) FILTER (WHERE copy_id IS NOT NULL), '{}')
+			FROM lapsed`
+
+		var (
+			count   int
+			copyIDs []int64
+		)
+
+		err = tx.QueryRow(query, bookID).Scan(&count, pq.Array(&copyIDs))
+		if err != nil {
+			return 0, err
+		}
+
+		for _, copyID := range copyIDs {
+			err = assignCopyToNextHold(tx, bookID, copyID, pickupWindow)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err = touchCirculation(tx, bookID)
+		if err != nil {
+			return 0, err
+		}
+
+		expired += count
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+func (m HoldModel) position(hold *Hold) (int, error) {
+	if hold.Status != HoldStatusWaiting {
+		return 0, nil
+	}
+
+	query := `
+		SELECT count(*)
+		FROM holds
+		WHERE book_id = $1 AND status = 'waiting' AND id <= $2`
+
+	var position int
+
+	err := m.DB.QueryRow(query, hold.BookID, hold.ID).Scan(&position)
+	return position, err
+}
+
+func assignCopyToNextHold(tx *sql.Tx, bookID, copyID int64, pickupWindow time.Duration) error {
+	query := `
+		UPDATE holds
+		SET status = 'ready', copy_id = $1, ready_at = NOW(), expires_at = NOW() + make_interval(secs => $2), version = version + 1
+		WHERE id = (
+			SELECT id
+			FROM holds
+			WHERE book_id = $3 AND status = 'waiting'
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	_, err := tx.Exec(query, copyID, pickupWindow.Seconds(), bookID)
+	return err
+}
+
+func claimHeldCopy(tx *sql.Tx, userID, bookID, copyID int64, pickupWindow time.Duration) error {
+	var holderID int64
+
+	query := `
+		SELECT user_id
+		FROM holds
+		WHERE copy_id = $1 AND status = 'ready'
+		FOR UPDATE`
+
+	err := tx.QueryRow(query, copyID).Scan(&holderID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case holderID != userID:
+		return ErrCopyUnavailable
+	}
+
+	query = `
+		UPDATE holds
+		SET status = 'fulfilled', version = version + 1
+		WHERE user_id = $1 AND book_id = $2 AND status IN ('waiting', 'ready')
+		RETURNING copy_id`
+
+	rows, err := tx.Query(query, userID, bookID)
+	if err != nil {
+		return err
+	}
+
+	var released []int64
+
+	for rows.Next() {
+		var heldCopyID *int64
+
+		err := rows.Scan(&heldCopyID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if heldCopyID != nil && *heldCopyID != copyID {
+			released = append(released, *heldCopyID)
+		}
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// The patron borrowed a different copy than the one set aside for them,
+	// so that copy goes to whoever is next in the queue.
+	for _, id := range released {
+		err = assignCopyToNextHold(tx, bookID, id, pickupWindow)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasWaitingHoldFromOthers(tx *sql.Tx, userID, bookID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM holds
+			WHERE book_id = $1 AND user_id <> $2 AND status = 'waiting'
+		)`
+
+	var exists bool
+
+	err := tx.QueryRow(query, bookID, userID).Scan(&exists)
+	return exists, err
+}
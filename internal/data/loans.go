@@ -0,0 +1,453 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Loan struct {
+	ID           int64         `json:"id"`
+	UserID       int64         `json:"user_id"`
+	CopyID       int64         `json:"copy_id"`
+	BookID       int64         `json:"book_id"`
+	BorrowedAt   time.Time     `json:"borrowed_at"`
+	DueAt        time.Time     `json:"due_at"`
+	ReturnedAt   *time.Time    `json:"returned_at,omitempty"`
+	RenewalCount int           `json:"renewal_count"`
+	Renewals     []LoanRenewal `json:"renewals,omitempty"`
+	Version      int32         `json:"version"`
+}
+
+type LoanRenewal struct {
+	RenewedAt     time.Time `json:"renewed_at"`
+	PreviousDueAt time.Time `json:"previous_due_at"`
+	DueAt         time.Time `json:"due_at"`
+}
+
+type LoanModel struct {
+	DB *sql.DB
+}
+
+// Borrow brings the user's fines up to date and refuses the loan if they owe
+// more than the policy allows. The check holds the user's ledger lock until
+// the loan commits, so no charge or credit can slip in between.
+func (m LoanModel) Borrow(userID, copyID int64, period, pickupWindow time.Duration, fines FinePolicy) (*Loan, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockLedger(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = accrue(tx, fines, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := ledgerBalance(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if balance > fines.MaxBalanceCents {
+		return nil, ErrFinesOutstanding
+	}
+
+	loan := &Loan{UserID: userID, CopyID: copyID}
+
+	err = tx.QueryRow(`SELECT book_id FROM copies WHERE id = $1`, copyID).Scan(&loan.BookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	err = lockBook(tx, loan.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+
+	query := `
+		SELECT status
+		FROM copies
+		WHERE id = $1
+		FOR UPDATE`
+
+	err = tx.QueryRow(query, copyID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	if status != CopyStatusAvailable {
+		return nil, ErrCopyUnavailable
+	}
+
+	err = claimHeldCopy(tx, userID, loan.BookID, copyID, pickupWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO loans (user_id, copy_id, due_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		RETURNING id, borrowed_at, due_at, version`
+
+	err = tx.QueryRow(query, userID, copyID, period.Seconds()).Scan(&loan.ID, &loan.BorrowedAt, &loan.DueAt, &loan.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return nil, ErrCopyUnavailable
+		case isForeignKeyViolationOn(err, "loans_user_id_fkey"):
+			return nil, ErrUnknownUser
+		default:
+			return nil, err
+		}
+	}
+
+	err = setCopyStatus(tx, copyID, CopyStatusOnLoan)
+	if err != nil {
+		return nil, err
+	}
+
+	err = touchCirculation(tx, loan.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+func (m LoanModel) Return(id int64, pickupWindow time.Duration) (*Loan, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+
+	query := `
+		SELECT copies.book_id
+		FROM loans
+		INNER JOIN copies ON copies.id = loans.copy_id
+		WHERE loans.id = $1`
+
+	err = tx.QueryRow(query, id).Scan(&bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	err = lockBook(tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT loans.id, loans.user_id, loans.copy_id, copies.book_id, loans.borrowed_at, loans.due_at, loans.returned_at, loans.renewal_count, loans.version
+		FROM loans
+		INNER JOIN copies ON copies.id = loans.copy_id
+		WHERE loans.id = $1
+		FOR UPDATE OF loans`
+
+	var loan Loan
+
+	err = tx.QueryRow(query, id).Scan(
+		&loan.ID,
+		&loan.UserID,
+		&loan.CopyID,
+		&loan.BookID,
+		&loan.BorrowedAt,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+		&loan.RenewalCount,
+		&loan.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	if loan.ReturnedAt != nil {
+		return nil, ErrLoanClosed
+	}
+
+	query = `
+		UPDATE loans
+		SET returned_at = NOW(), version = version + 1
+		WHERE id = $1
+		RETURNING returned_at, version`
+
+	err = tx.QueryRow(query, loan.ID).Scan(&loan.ReturnedAt, &loan.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setCopyStatus(tx, loan.CopyID, CopyStatusAvailable)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assignCopyToNextHold(tx, loan.BookID, loan.CopyID, pickupWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	err = touchCirculation(tx, loan.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+func (m LoanModel) Get(id int64) (*Loan, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	query := `
+		SELECT loans.id, loans.user_id, loans.copy_id, copies.book_id, loans.borrowed_at, loans.due_at, loans.returned_at, loans.renewal_count, loans.version
+		FROM loans
+		INNER JOIN copies ON copies.id = loans.copy_id
+		WHERE loans.id = $1`
+
+	var loan Loan
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&loan.ID,
+		&loan.UserID,
+		&loan.CopyID,
+		&loan.BookID,
+		&loan.BorrowedAt,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+		&loan.RenewalCount,
+		&loan.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	return &loan, nil
+}
+
+func (m LoanModel) Renew(id int64, period time.Duration, maxRenewals int) (*Loan, error) {
+	if id < 1 {
+		return nil, recordNotFound()
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT loans.id, loans.user_id, loans.copy_id, copies.book_id, loans.borrowed_at, loans.due_at, loans.returned_at, loans.renewal_count, loans.version
+		FROM loans
+		INNER JOIN copies ON copies.id = loans.copy_id
+		WHERE loans.id = $1
+		FOR UPDATE OF loans`
+
+	var loan Loan
+
+	err = tx.QueryRow(query, id).Scan(
+		&loan.ID,
+		&loan.UserID,
+		&loan.CopyID,
+		&loan.BookID,
+		&loan.BorrowedAt,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+		&loan.RenewalCount,
+		&loan.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, recordNotFound()
+		} else {
+			return nil, err
+		}
+	}
+
+	if loan.ReturnedAt != nil {
+		return nil, ErrLoanClosed
+	}
+
+	if loan.RenewalCount >= maxRenewals {
+		return nil, ErrRenewalLimitReached
+	}
+
+	held, err := hasWaitingHoldFromOthers(tx, loan.UserID, loan.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if held {
+		return nil, ErrHoldPending
+	}
+
+	query = `
+		INSERT INTO loan_renewals (loan_id, previous_due_at, due_at)
+		VALUES ($1, $2, $2 + make_interval(secs => $3))
+		RETURNING due_at`
+
+	err = tx.QueryRow(query, loan.ID, loan.DueAt, period.Seconds()).Scan(&loan.DueAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE loans
+		SET due_at = $1, renewal_count = renewal_count + 1, version = version + 1
+		WHERE id = $2
+		RETURNING renewal_count, version`
+
+	err = tx.QueryRow(query, loan.DueAt, loan.ID).Scan(&loan.RenewalCount, &loan.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Renewals, err = m.GetRenewals(loan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+func (m LoanModel) GetRenewals(loanID int64) ([]LoanRenewal, error) {
+	query := `
+		SELECT renewed_at, previous_due_at, due_at
+		FROM loan_renewals
+		WHERE loan_id = $1
+		ORDER BY renewed_at, id`
+
+	rows, err := m.DB.Query(query, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renewals := []LoanRenewal{}
+
+	for rows.Next() {
+		var renewal LoanRenewal
+
+		err := rows.Scan(&renewal.RenewedAt, &renewal.PreviousDueAt, &renewal.DueAt)
+		if err != nil {
+			return nil, err
+		}
+
+		renewals = append(renewals, renewal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return renewals, nil
+}
+
+func (m LoanModel) GetAllForUser(userID int64, filter Filter) ([]*Loan, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), loans.id, loans.user_id, loans.copy_id, copies.book_id, loans.borrowed_at, loans.due_at, loans.returned_at, loans.renewal_count, loans.version
+		FROM loans
+		INNER JOIN copies ON copies.id = loans.copy_id
+		WHERE loans.user_id = $1
+		ORDER BY loans.%s %s, loans.id ASC
+		LIMIT $2 OFFSET $3`, filter.sortColumn(), filter.sortDirection())
+
+	rows, err := m.DB.Query(query, userID, filter.limit(), filter.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	loans := []*Loan{}
+
+	for rows.Next() {
+		var loan Loan
+
+		err := rows.Scan(
+			&totalRecords,
+			&loan.ID,
+			&loan.UserID,
+			&loan.CopyID,
+			&loan.BookID,
+			&loan.BorrowedAt,
+			&loan.DueAt,
+			&loan.ReturnedAt,
+			&loan.RenewalCount,
+			&loan.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		loans = append(loans, &loan)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filter.Page, filter.PageSize)
+
+	return loans, metadata, nil
+}
+
+func setCopyStatus(tx *sql.Tx, copyID int64, status string) error {
+	query := `
+		UPDATE copies
+		SET status = $1, version = version + 1
+		WHERE id = $2`
+
+	_, err := tx.Exec(query, status, copyID)
+	return err
+}
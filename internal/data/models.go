@@ -3,19 +3,99 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
+	"sync/atomic"
 )
 
 var (
-	ErrRecordNotFound = errors.New("record not found")
-	ErrEditConflict   = errors.New("edit conflict")
+	ErrRecordNotFound      = errors.New("record not found")
+	ErrEditConflict        = errors.New("edit conflict")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrDuplicateCredit     = errors.New("duplicate credit")
+	ErrDuplicateGenre      = errors.New("duplicate genre")
+	ErrDuplicateBarcode    = errors.New("duplicate barcode")
+	ErrCopyUnavailable     = errors.New("copy unavailable")
+	ErrLoanClosed          = errors.New("loan closed")
+	ErrRenewalLimitReached = errors.New("renewal limit reached")
+	ErrHoldPending         = errors.New("hold pending")
+	ErrCopyAvailable       = errors.New("copy available")
+	ErrDuplicateHold       = errors.New("duplicate hold")
+	ErrDuplicateEmail      = errors.New("duplicate email")
+	ErrUnknownUser         = errors.New("unknown user")
+	ErrHasLoanHistory      = errors.New("has loan history")
+	ErrCreditExceedsOwed   = errors.New("credit exceeds balance owed")
+	ErrFinesOutstanding    = errors.New("fines outstanding")
+	ErrDuplicateAuthor     = errors.New("duplicate author")
 )
 
+var (
+	recordNotFoundCount atomic.Int64
+	editConflictCount   atomic.Int64
+)
+
+func recordNotFound() error {
+	recordNotFoundCount.Add(1)
+	return ErrRecordNotFound
+}
+
+func editConflict() error {
+	editConflictCount.Add(1)
+	return ErrEditConflict
+}
+
+// RecordNotFoundCount and EditConflictCount report how often the models have
+// returned each sentinel since the process started.
+func RecordNotFoundCount() int64 {
+	return recordNotFoundCount.Load()
+}
+
+func EditConflictCount() int64 {
+	return editConflictCount.Load()
+}
+
 type Models struct {
-	Books BookModel
+	Books       BookModel
+	Authors     AuthorModel
+	Genres      GenreModel
+	Copies      CopyModel
+	Loans       LoanModel
+	Holds       HoldModel
+	Fines       FineModel
+	Users       UserModel
+	Tokens      TokenModel
+	Permissions PermissionModel
+	APIKeys     APIKeyModel
+	Health      HealthModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Books: BookModel{DB: db},
+		Books:       BookModel{DB: db},
+		Authors:     AuthorModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Copies:      CopyModel{DB: db},
+		Loans:       LoanModel{DB: db},
+		Holds:       HoldModel{DB: db},
+		Fines:       FineModel{DB: db},
+		Users:       UserModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		APIKeys:     APIKeyModel{DB: db},
+		Health:      HealthModel{DB: db},
 	}
 }
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func isForeignKeyViolationOn(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == constraint
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/xuche123/bookwise/internal/validator"
+)
+
+var Roles = []string{"patron", "librarian", "admin"}
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateRoles(v *validator.Validator, roles []string) {
+	v.Check(len(roles) > 0, "roles", "must contain at least one entry")
+	v.Check(validator.Unique(roles), "roles", "must not contain duplicate values")
+
+	for _, role := range roles {
+		v.Check(validator.PermittedValue(role, Roles...), "roles", "must only contain patron, librarian or admin")
+	}
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	_, err := m.DB.Exec(query, userID, pq.Array(codes))
+	return err
+}
+
+func (m PermissionModel) AddRolesForUser(userID int64, roles ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	_, err := m.DB.Exec(query, userID, pq.Array(roles))
+	return err
+}
+
+func (m PermissionModel) SetRolesForUser(userID int64, roles ...string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM users_roles WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)`
+
+	_, err = tx.Exec(query, userID, pq.Array(roles))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return recordNotFound()
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -1,18 +1,24 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,8 +26,12 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -31,48 +41,211 @@ func (l Level) String() string {
 	}
 }
 
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("jsonlog: unknown level %q", s)
+	}
+}
+
+// Field is a typed property attached to a log entry. Build them with the
+// constructors below so values keep their JSON type instead of being
+// flattened to strings.
+type Field struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration is written as fractional milliseconds.
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: float64(value) / float64(time.Millisecond)}
+}
+
+func Time(key string, value time.Time) Field {
+	return Field{Key: key, Value: value.UTC().Format(time.RFC3339Nano)}
+}
+
+// Object nests fields under key as a JSON object.
+func Object(key string, fields ...Field) Field {
+	return Field{Key: key, Value: fieldMap(nil, nil, fields)}
+}
+
+// Any is for values that already marshal to sensible JSON.
+func Any(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// sink is the output a logger and all of its children write to. The mutex
+// lives here so that lines from a parent and its children never interleave.
+type sink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// Logger writes one JSON object per line. Create Loggers with New; the zero
+// value has no output and is not usable.
 type Logger struct {
-	out      io.Writer
+	sink     *sink
 	minLevel Level
-	mu       sync.Mutex
+	fields   []Field
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
-		out:      out,
+		sink:     &sink{out: out},
 		minLevel: minLevel,
 	}
 }
 
+// With returns a child logger that adds fields to every entry it writes. The
+// child shares its parent's output and level.
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &child
+}
+
+type contextKey struct{}
+
+var defaultLogger atomic.Pointer[Logger]
+
+func init() {
+	defaultLogger.Store(New(os.Stdout, LevelInfo))
+}
+
+// SetDefault makes l the logger FromContext falls back to. Applications call
+// it once at startup so that code without a request logger still honours the
+// configured output and level.
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+// Default returns the logger set by SetDefault, or one writing INFO and above
+// to stdout if it was never called.
+func Default() *Logger {
+	return defaultLogger.Load()
+}
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored by NewContext, or Default if there
+// isn't one.
+func FromContext(ctx context.Context) *Logger {
+	l, ok := ctx.Value(contextKey{}).(*Logger)
+	if !ok {
+		return Default()
+	}
+
+	return l
+}
+
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.print(LevelDebug, message, nil, fields)
+}
+
+func (l *Logger) Info(message string, fields ...Field) {
+	l.print(LevelInfo, message, nil, fields)
+}
+
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.print(LevelWarn, message, nil, fields)
+}
+
+func (l *Logger) Error(err error, fields ...Field) {
+	l.print(LevelError, errorMessage(err), nil, fields)
+}
+
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, properties, nil)
 }
 
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, errorMessage(err), properties, nil)
 }
 
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, errorMessage(err), properties, nil)
 	os.Exit(1)
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func errorMessage(err error) string {
+	if err == nil {
+		return "<nil error>"
+	}
+
+	return err.Error()
+}
+
+// fieldMap merges inherited fields, then explicit properties, then per-call
+// fields, so the most specific value wins when keys collide.
+func fieldMap(inherited []Field, properties map[string]string, fields []Field) map[string]any {
+	m := make(map[string]any, len(inherited)+len(properties)+len(fields))
+
+	for _, field := range inherited {
+		m[field.Key] = field.Value
+	}
+
+	for key, value := range properties {
+		m[key] = value
+	}
+
+	for _, field := range fields {
+		m[field.Key] = field.Value
+	}
+
+	return m
+}
+
+func (l *Logger) print(level Level, message string, properties map[string]string, fields []Field) (int, error) {
 	if level < l.minLevel {
 		return 0, nil
 	}
 
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
 		Message:    message,
-		Properties: properties,
+		Properties: fieldMap(l.fields, properties, fields),
 	}
 
 	if level >= LevelError {
@@ -86,12 +259,12 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
 
-	return l.out.Write(append(line, '\n'))
+	return l.sink.out.Write(append(line, '\n'))
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.print(LevelError, string(message), nil, nil)
 }
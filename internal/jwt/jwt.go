@@ -0,0 +1,233 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	IssuedAt    int64    `json:"iat"`
+	NotBefore   int64    `json:"nbf"`
+	ExpiresAt   int64    `json:"exp"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type Key struct {
+	ID         string
+	Algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+}
+
+type KeySet struct {
+	issuer  string
+	signing *Key
+	keys    map[string]*Key
+}
+
+// ParseKeySet reads a comma-separated list of kid:alg:base64-key entries. The
+// first entry signs new tokens, every entry verifies, so rotating a key means
+// prepending the new one and dropping the old one once its tokens expire.
+func ParseKeySet(issuer, spec string) (*KeySet, error) {
+	ks := &KeySet{issuer: issuer, keys: make(map[string]*Key)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("jwt: key entry must be kid:alg:key")
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q is not valid base64: %w", parts[0], err)
+		}
+
+		key := &Key{ID: parts[0], Algorithm: parts[1]}
+
+		switch key.Algorithm {
+		case AlgorithmHS256:
+			if len(material) < 32 {
+				return nil, fmt.Errorf("jwt: HS256 key %q must be at least 32 bytes", key.ID)
+			}
+			key.secret = material
+		case AlgorithmEdDSA:
+			if len(material) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt: EdDSA key %q must be a %d byte seed", key.ID, ed25519.SeedSize)
+			}
+			key.privateKey = ed25519.NewKeyFromSeed(material)
+		default:
+			return nil, fmt.Errorf("jwt: key %q has unsupported algorithm %q", key.ID, key.Algorithm)
+		}
+
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+
+		ks.keys[key.ID] = key
+		if ks.signing == nil {
+			ks.signing = key
+		}
+	}
+
+	return ks, nil
+}
+
+func GenerateKeySet(issuer string) (*KeySet, error) {
+	seed := make([]byte, ed25519.SeedSize)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := base64.RawURLEncoding.EncodeToString(seed[:6])
+
+	return ParseKeySet(issuer, kid+":"+AlgorithmEdDSA+":"+base64.StdEncoding.EncodeToString(seed))
+}
+
+func (ks *KeySet) Sign(subject string, ttl time.Duration, activated bool, permissions []string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	claims := Claims{
+		Issuer:      ks.issuer,
+		Subject:     subject,
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		Activated:   activated,
+		Permissions: permissions,
+	}
+
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(ks.signing.sign([]byte(signingInput))), expiry, nil
+}
+
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok || key.Algorithm != h.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil || claims.Issuer != ks.issuer {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now().Unix()
+	if now < claims.NotBefore || now >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (ks *KeySet) JWKS() map[string]any {
+	keys := []map[string]string{}
+
+	for _, key := range ks.keys {
+		if key.Algorithm != AlgorithmEdDSA {
+			continue
+		}
+
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"alg": AlgorithmEdDSA,
+			"use": "sig",
+			"kid": key.ID,
+			"x":   base64.RawURLEncoding.EncodeToString(key.privateKey.Public().(ed25519.PublicKey)),
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i]["kid"] < keys[j]["kid"]
+	})
+
+	return map[string]any{"keys": keys}
+}
+
+func (k *Key) sign(input []byte) []byte {
+	if k.Algorithm == AlgorithmEdDSA {
+		return ed25519.Sign(k.privateKey, input)
+	}
+
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	if k.Algorithm == AlgorithmEdDSA {
+		return ed25519.Verify(k.privateKey.Public().(ed25519.PublicKey), input, signature)
+	}
+
+	return hmac.Equal(k.sign(input), signature)
+}
+
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,186 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIssuer = "bookwise-test"
+
+var (
+	hsSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	edSeed   = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func mustParseKeySet(t *testing.T, spec string) *KeySet {
+	t.Helper()
+
+	ks, err := ParseKeySet(testIssuer, spec)
+	if err != nil {
+		t.Fatalf("ParseKeySet: %v", err)
+	}
+
+	return ks
+}
+
+// craft signs arbitrary header and claims with key, for building tokens Sign
+// would never produce.
+func craft(t *testing.T, key *Key, h header, claims Claims) string {
+	t.Helper()
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(key.sign([]byte(input)))
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	for _, spec := range []string{"hs:HS256:" + hsSecret, "ed:EdDSA:" + edSeed} {
+		t.Run(strings.SplitN(spec, ":", 3)[1], func(t *testing.T) {
+			ks := mustParseKeySet(t, spec)
+
+			token, expiry, err := ks.Sign("42", time.Hour, true, []string{"books:read"})
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			if d := time.Until(expiry); d < 59*time.Minute || d > time.Hour {
+				t.Errorf("got expiry %s away, want about an hour", d)
+			}
+
+			claims, err := ks.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if claims.Subject != "42" || claims.Issuer != testIssuer || !claims.Activated {
+				t.Errorf("got claims %+v", claims)
+			}
+
+			if len(claims.Permissions) != 1 || claims.Permissions[0] != "books:read" {
+				t.Errorf("got permissions %v, want [books:read]", claims.Permissions)
+			}
+		})
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	old := mustParseKeySet(t, "old:HS256:"+hsSecret)
+
+	token, _, err := old.Sign("42", time.Hour, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustParseKeySet(t, "new:EdDSA:"+edSeed+",old:HS256:"+hsSecret)
+
+	if _, err := rotated.Verify(token); err != nil {
+		t.Errorf("token from a retired signing key: got %v, want it to verify", err)
+	}
+
+	dropped := mustParseKeySet(t, "new:EdDSA:"+edSeed)
+
+	if _, err := dropped.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token from a dropped key: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ks := mustParseKeySet(t, "hs:HS256:"+hsSecret+",ed:EdDSA:"+edSeed)
+	hs, ed := ks.keys["hs"], ks.keys["ed"]
+
+	now := time.Now().Unix()
+	valid := Claims{Issuer: testIssuer, Subject: "42", IssuedAt: now, NotBefore: now, ExpiresAt: now + 3600}
+
+	with := func(change func(*Claims)) Claims {
+		c := valid
+		change(&c)
+		return c
+	}
+
+	good := craft(t, hs, header{Algorithm: AlgorithmHS256, Type: "JWT", KeyID: "hs"}, valid)
+	parts := strings.Split(good, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"sanity check", good, nil},
+		{"two segments", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"header not base64", "!!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"unknown kid", craft(t, hs, header{Algorithm: AlgorithmHS256, KeyID: "missing"}, valid), ErrInvalidToken},
+		{"alg does not match kid", craft(t, hs, header{Algorithm: AlgorithmEdDSA, KeyID: "hs"}, valid), ErrInvalidToken},
+		{"alg none", craft(t, hs, header{Algorithm: "none", KeyID: "hs"}, valid), ErrInvalidToken},
+		{"signed by another key", craft(t, ed, header{Algorithm: AlgorithmHS256, KeyID: "hs"}, valid), ErrInvalidToken},
+		{"claims tampered", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"bookwise-test","sub":"1"}`)) + "." + parts[2], ErrInvalidToken},
+		{"signature stripped", parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"wrong issuer", craft(t, hs, header{Algorithm: AlgorithmHS256, KeyID: "hs"}, with(func(c *Claims) { c.Issuer = "someone-else" })), ErrInvalidToken},
+		{"expired", craft(t, hs, header{Algorithm: AlgorithmHS256, KeyID: "hs"}, with(func(c *Claims) { c.ExpiresAt = now - 1 })), ErrExpiredToken},
+		{"expires now", craft(t, hs, header{Algorithm: AlgorithmHS256, KeyID: "hs"}, with(func(c *Claims) { c.ExpiresAt = now })), ErrExpiredToken},
+		{"not yet valid", craft(t, hs, header{Algorithm: AlgorithmHS256, KeyID: "hs"}, with(func(c *Claims) { c.NotBefore = now + 3600 })), ErrExpiredToken},
+		{"eddsa", craft(t, ed, header{Algorithm: AlgorithmEdDSA, KeyID: "ed"}, valid), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ks.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeySetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"missing parts", "hs:HS256"},
+		{"bad base64", "hs:HS256:***"},
+		{"short hmac secret", "hs:HS256:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"wrong seed size", "ed:EdDSA:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"unsupported algorithm", "rs:RS256:" + hsSecret},
+		{"duplicate kid", "hs:HS256:" + hsSecret + ",hs:EdDSA:" + edSeed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeySet(testIssuer, tt.spec); err == nil {
+				t.Errorf("ParseKeySet(%q) succeeded, want an error", tt.spec)
+			}
+		})
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	ks := mustParseKeySet(t, "hs:HS256:"+hsSecret+",ed:EdDSA:"+edSeed)
+
+	keys := ks.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want only the EdDSA key", len(keys))
+	}
+
+	if keys[0]["kid"] != "ed" || keys[0]["crv"] != "Ed25519" || keys[0]["d"] != "" {
+		t.Errorf("got %v", keys[0])
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(keys[0]["x"])
+	if err != nil || len(x) != 32 {
+		t.Errorf("got x %q, want a 32 byte public key", keys[0]["x"])
+	}
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	r.NewGaugeFunc("db_open_connections", "Open database connections.", func() float64 { return 3 })
+	r.NewCounterFunc("errors_total", "Errors returned.\nCounts \\ escapes.", func() float64 { return 1.5 })
+
+	h := r.NewHistogramVec("request_duration_seconds", "Request latency.", []float64{1, 0.1, 0.5}, "route", "method")
+
+	h.Observe(0.05, "/v1/books", "GET")
+	h.Observe(0.3, "/v1/books", "GET")
+	h.Observe(2, "/v1/books", "GET")
+	h.Observe(0.1, `/v1/"quoted"\path`+"\n", "POST")
+
+	var buf bytes.Buffer
+
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+
+	golden := filepath.Join("testdata", "exposition.golden")
+
+	if *update {
+		err := os.WriteFile(golden, buf.Bytes(), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("exposition output mismatch\n--- got ---\n%s\n--- want ---\n%s", buf.Bytes(), want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "Whether the service is up.", func() float64 { return 1 })
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got, want := rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+
+	if got, want := rec.Body.String(), "# HELP up Whether the service is up.\n# TYPE up gauge\nup 1\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{0.005, "0.005"},
+		{2.5, "2.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		names      []string
+		values     []string
+		extraName  string
+		extraValue string
+		want       string
+	}{
+		{"no labels", nil, nil, "", "", ""},
+		{"extra only", nil, nil, "le", "+Inf", `{le="+Inf"}`},
+		{"plain", []string{"route", "method"}, []string{"/v1/books", "GET"}, "", "", `{route="/v1/books",method="GET"}`},
+		{"with extra", []string{"route"}, []string{"/"}, "le", "0.5", `{route="/",le="0.5"}`},
+		{"escaped", []string{"route"}, []string{"a\\b\"c\nd"}, "", "", `{route="a\\b\"c\nd"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatLabels(tt.names, tt.values, tt.extraName, tt.extraValue); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "", func() float64 { return 1 })
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name did not panic")
+		}
+	}()
+
+	r.NewCounterFunc("up", "", func() float64 { return 1 })
+}
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(500) NOT NULL,
+    biography TEXT,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id BIGINT NOT NULL REFERENCES books ON DELETE CASCADE,
+    author_id BIGINT NOT NULL REFERENCES authors ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'author' CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+    position INT NOT NULL DEFAULT 1,
+    PRIMARY KEY (book_id, author_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id);
+
+INSERT INTO authors (name)
+SELECT min(trim(author))
+FROM books
+WHERE trim(author) <> ''
+GROUP BY regexp_replace(lower(author), '[^[:alnum:]]', '', 'g');
+
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT books.id, authors.id, 'author', 1
+FROM books
+JOIN authors ON regexp_replace(lower(authors.name), '[^[:alnum:]]', '', 'g') = regexp_replace(lower(books.author), '[^[:alnum:]]', '', 'g');
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    parent_id BIGINT REFERENCES genres ON DELETE RESTRICT,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS genres_parent_id_name_idx ON genres (coalesce(parent_id, 0), lower(name));
+
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id BIGINT NOT NULL REFERENCES books ON DELETE CASCADE,
+    genre_id BIGINT NOT NULL REFERENCES genres ON DELETE CASCADE,
+    PRIMARY KEY (book_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_genres_genre_id_idx ON book_genres (genre_id);
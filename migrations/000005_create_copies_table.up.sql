@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS copies (
+    id BIGSERIAL PRIMARY KEY,
+    book_id BIGINT NOT NULL REFERENCES books ON DELETE CASCADE,
+    barcode VARCHAR(100) NOT NULL UNIQUE,
+    branch VARCHAR(200) NOT NULL,
+    shelf_location VARCHAR(200) NOT NULL DEFAULT '',
+    condition VARCHAR(20) NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged')),
+    status VARCHAR(20) NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on-loan', 'lost', 'in-repair', 'withdrawn')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS copies_book_id_idx ON copies (book_id);
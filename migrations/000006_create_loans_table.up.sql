@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS loans (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    copy_id BIGINT NOT NULL REFERENCES copies ON DELETE RESTRICT,
+    borrowed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    due_at timestamp(0) with time zone NOT NULL,
+    returned_at timestamp(0) with time zone,
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS loans_user_id_idx ON loans (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS loans_open_copy_id_idx ON loans (copy_id) WHERE returned_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS loan_renewals;
+ALTER TABLE loans DROP COLUMN IF EXISTS renewal_count;
@@ -0,0 +1,11 @@
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS renewal_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS loan_renewals (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans ON DELETE CASCADE,
+    renewed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    previous_due_at timestamp(0) with time zone NOT NULL,
+    due_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS loan_renewals_loan_id_idx ON loan_renewals (loan_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS holds (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    book_id BIGINT NOT NULL REFERENCES books ON DELETE CASCADE,
+    copy_id BIGINT REFERENCES copies ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'ready', 'fulfilled', 'expired', 'cancelled')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ready_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone,
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS holds_book_id_status_idx ON holds (book_id, status, id);
+CREATE UNIQUE INDEX IF NOT EXISTS holds_open_user_id_book_id_idx ON holds (user_id, book_id) WHERE status IN ('waiting', 'ready');
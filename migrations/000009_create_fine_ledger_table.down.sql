@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS fine_ledger;
+DROP FUNCTION IF EXISTS fine_ledger_append_only();
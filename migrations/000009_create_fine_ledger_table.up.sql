@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS fine_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    loan_id BIGINT REFERENCES loans ON DELETE RESTRICT,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('charge', 'payment', 'waiver')),
+    amount_cents BIGINT NOT NULL CHECK ((kind = 'charge' AND amount_cents > 0) OR (kind <> 'charge' AND amount_cents < 0)),
+    description TEXT NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS fine_ledger_user_id_idx ON fine_ledger (user_id);
+CREATE INDEX IF NOT EXISTS fine_ledger_loan_id_idx ON fine_ledger (loan_id);
+
+CREATE OR REPLACE FUNCTION fine_ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'fine_ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER fine_ledger_append_only
+BEFORE UPDATE OR DELETE ON fine_ledger
+FOR EACH ROW EXECUTE FUNCTION fine_ledger_append_only();
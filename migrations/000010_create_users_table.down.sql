@@ -0,0 +1,4 @@
+ALTER TABLE fine_ledger DROP CONSTRAINT IF EXISTS fine_ledger_user_id_fkey;
+ALTER TABLE holds DROP CONSTRAINT IF EXISTS holds_user_id_fkey;
+ALTER TABLE loans DROP CONSTRAINT IF EXISTS loans_user_id_fkey;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,15 @@
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    email citext UNIQUE NOT NULL,
+    password_hash bytea NOT NULL,
+    activated BOOL NOT NULL,
+    version INT NOT NULL DEFAULT 1
+);
+
+ALTER TABLE loans ADD CONSTRAINT loans_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE RESTRICT NOT VALID;
+ALTER TABLE holds ADD CONSTRAINT holds_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE NOT VALID;
+ALTER TABLE fine_ledger ADD CONSTRAINT fine_ledger_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE RESTRICT NOT VALID;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS permissions;
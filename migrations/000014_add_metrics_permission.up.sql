@@ -0,0 +1,7 @@
+INSERT INTO permissions (code)
+VALUES ('metrics:view');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'metrics:view';
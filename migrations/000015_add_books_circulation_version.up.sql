@@ -0,0 +1 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS circulation_version integer NOT NULL DEFAULT 1;
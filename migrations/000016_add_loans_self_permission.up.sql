@@ -0,0 +1,7 @@
+INSERT INTO permissions (code)
+VALUES ('loans:self');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('patron', 'librarian', 'admin') AND permissions.code = 'loans:self';
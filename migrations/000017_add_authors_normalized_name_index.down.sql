@@ -0,0 +1 @@
+DROP INDEX IF EXISTS authors_normalized_name_idx;
@@ -0,0 +1,23 @@
+-- Fold authors whose names only differ by case, spacing or punctuation into
+-- the oldest of them before the index makes such duplicates impossible.
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT book_authors.book_id, canonical.keep_id, book_authors.role, book_authors.position
+FROM book_authors
+JOIN (
+    SELECT id, min(id) OVER (PARTITION BY regexp_replace(lower(name), '[^[:alnum:]]', '', 'g')) AS keep_id
+    FROM authors
+) AS canonical ON canonical.id = book_authors.author_id
+WHERE canonical.id <> canonical.keep_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM authors
+WHERE id IN (
+    SELECT id
+    FROM (
+        SELECT id, min(id) OVER (PARTITION BY regexp_replace(lower(name), '[^[:alnum:]]', '', 'g')) AS keep_id
+        FROM authors
+    ) AS canonical
+    WHERE id <> keep_id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS authors_normalized_name_idx ON authors ((regexp_replace(lower(name), '[^[:alnum:]]', '', 'g')));